@@ -56,3 +56,17 @@ func Login(c *fiber.Ctx) error {
 		"token": t,
 	})
 }
+
+// currentUserID returns the ID of the user the request's token was issued to
+func currentUserID(c *fiber.Ctx) uint {
+	token, ok := c.Locals("user").(*jwt.Token)
+	if !ok {
+		return 0
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return 0
+	}
+	id, _ := claims["id"].(float64)
+	return uint(id)
+}
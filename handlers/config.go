@@ -3,6 +3,7 @@ package handlers
 
 import (
 	"sitemap-builder/models"
+	"sitemap-builder/services"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -33,7 +34,7 @@ func CreateConfig(c *fiber.Ctx) error {
 	}
 
 	// Validate required fields
-	if config.SitemapID == 0 || config.DatasourceID == 0 || config.TableName == "" {
+	if config.SitemapID == 0 || config.DatasourceID == 0 {
 		return c.Status(400).JSON(fiber.Map{"error": "Missing required fields"})
 	}
 
@@ -49,12 +50,22 @@ func CreateConfig(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid DatasourceID"})
 	}
 
+	// Crawl and static datasources have no tables to query
+	if config.TableName == "" && datasource.Type != "crawl" && datasource.Type != "static" {
+		return c.Status(400).JSON(fiber.Map{"error": "Missing required fields"})
+	}
+
 	// Check if sitemap already has a config
 	var existingConfig models.SitemapConfig
 	if result := DB.Where("sitemap_id = ?", config.SitemapID).First(&existingConfig); result.Error == nil {
 		return c.Status(400).JSON(fiber.Map{"error": "Sitemap already has a configuration"})
 	}
 
+	// Check the URL pattern and mapped columns against the datasource
+	if problem := checkConfigColumns(&datasource, config, sitemap.Type); problem != nil {
+		return c.Status(400).JSON(problem)
+	}
+
 	DB.Create(&config)
 	return c.JSON(config)
 }
@@ -115,6 +126,15 @@ func UpdateConfig(c *fiber.Ctx) error {
 		config.Priority = updateData.Priority
 	}
 
+	// Check the URL pattern and mapped columns against the (possibly new) datasource
+	var sitemap models.Sitemap
+	DB.First(&sitemap, config.SitemapID)
+	var datasource models.Datasource
+	DB.First(&datasource, config.DatasourceID)
+	if problem := checkConfigColumns(&datasource, &config, sitemap.Type); problem != nil {
+		return c.Status(400).JSON(problem)
+	}
+
 	DB.Save(&config)
 	return c.JSON(config)
 }
@@ -131,3 +151,96 @@ func DeleteConfig(c *fiber.Ctx) error {
 	DB.Delete(&config)
 	return c.JSON(fiber.Map{"message": "Config deleted"})
 }
+
+// PreviewConfig renders sample URLs and XML for a saved configuration
+func PreviewConfig(c *fiber.Ctx) error {
+	id := c.Params("id")
+	var config models.SitemapConfig
+	result := DB.First(&config, id)
+	if result.Error != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Config not found"})
+	}
+
+	var sitemap models.Sitemap
+	if result := DB.First(&sitemap, config.SitemapID); result.Error != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid SitemapID"})
+	}
+	sitemap.Config = config
+
+	return previewSitemap(c, &sitemap)
+}
+
+// PreviewUnsavedConfig renders sample URLs and XML for a configuration given in the request body.
+// The sitemap type is taken from the body's "type", or else from its sitemap.
+func PreviewUnsavedConfig(c *fiber.Ctx) error {
+	type PreviewRequest struct {
+		models.SitemapConfig
+		Type string `json:"type"`
+	}
+
+	req := new(PreviewRequest)
+	if err := c.BodyParser(req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if req.DatasourceID == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Missing required fields"})
+	}
+
+	sitemap := models.Sitemap{Type: req.Type}
+	if sitemap.Type == "" && req.SitemapID != 0 {
+		if result := DB.First(&sitemap, req.SitemapID); result.Error != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid SitemapID"})
+		}
+	}
+
+	// Apply the defaults the database would
+	if req.PublicationName == "" {
+		req.PublicationName = "Default News Publication"
+	}
+	if req.DefaultLanguage == "" {
+		req.DefaultLanguage = "en"
+	}
+	sitemap.Config = req.SitemapConfig
+
+	return previewSitemap(c, &sitemap)
+}
+
+func previewSitemap(c *fiber.Ctx, sitemap *models.Sitemap) error {
+	var datasource models.Datasource
+	if result := DB.First(&datasource, sitemap.Config.DatasourceID); result.Error != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid DatasourceID"})
+	}
+
+	limit := c.QueryInt("limit", 10)
+	if limit < 1 || limit > 100 {
+		limit = 10
+	}
+
+	preview, err := services.PreviewSitemap(DB, sitemap, limit)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Preview failed",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(preview)
+}
+
+// checkConfigColumns verifies that every column a config uses exists in its datasource,
+// returning the error response body if it does not
+func checkConfigColumns(datasource *models.Datasource, config *models.SitemapConfig, sitemapType string) fiber.Map {
+	missing, err := services.MissingConfigColumns(DB, datasource, config, sitemapType)
+	if err != nil {
+		return fiber.Map{
+			"error": "Unable to inspect datasource",
+			"details": err.Error(),
+		}
+	}
+	if len(missing) > 0 {
+		return fiber.Map{
+			"error": "Columns not found in datasource",
+			"missing_columns": missing,
+		}
+	}
+	return nil
+}
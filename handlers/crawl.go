@@ -0,0 +1,56 @@
+// handlers/crawl.go
+package handlers
+
+import (
+	"log"
+	"sitemap-builder/models"
+	"sitemap-builder/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// StartCrawl starts (or resumes) the crawl of a crawl datasource
+func StartCrawl(c *fiber.Ctx) error {
+	id := c.Params("id")
+	var datasource models.Datasource
+	if result := DB.First(&datasource, id); result.Error != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Datasource not found"})
+	}
+	if datasource.Type != "crawl" {
+		return c.Status(400).JSON(fiber.Map{"error": "Datasource is not a crawl datasource"})
+	}
+
+	if services.IsCrawlRunning(datasource.ID) {
+		return c.Status(409).JSON(fiber.Map{"error": "Crawl already running"})
+	}
+
+	go func() {
+		if err := services.RunCrawl(DB, &datasource); err != nil {
+			log.Printf("Error crawling datasource %s: %v", datasource.Name, err)
+		}
+	}()
+
+	return c.JSON(fiber.Map{"message": "Crawl started"})
+}
+
+// GetCrawlStatus returns the progress of a crawl datasource's crawl
+func GetCrawlStatus(c *fiber.Ctx) error {
+	id := c.Params("id")
+	var datasource models.Datasource
+	if result := DB.First(&datasource, id); result.Error != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Datasource not found"})
+	}
+	if datasource.Type != "crawl" {
+		return c.Status(400).JSON(fiber.Map{"error": "Datasource is not a crawl datasource"})
+	}
+
+	pages, err := services.CrawlStatus(DB, datasource.ID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to load crawl status"})
+	}
+
+	return c.JSON(fiber.Map{
+		"running": services.IsCrawlRunning(datasource.ID),
+		"pages":   pages,
+	})
+}
@@ -3,16 +3,24 @@ package handlers
 
 import (
 	"fmt"
+	"log"
+	"net/url"
 	"sitemap-builder/models"
+	"sitemap-builder/secrets"
+	"sitemap-builder/services"
 	"sitemap-builder/utils"
 
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
 )
 
 // GetDatasources returns all datasources
 func GetDatasources(c *fiber.Ctx) error {
 	var datasources []models.Datasource
-	DB.Find(&datasources)
+	DB.Preload("CrawlConfig").Find(&datasources)
+	for i := range datasources {
+		datasources[i] = datasources[i].Redacted()
+	}
 	return c.JSON(datasources)
 }
 
@@ -20,11 +28,11 @@ func GetDatasources(c *fiber.Ctx) error {
 func GetDatasource(c *fiber.Ctx) error {
 	id := c.Params("id")
 	var datasource models.Datasource
-	result := DB.First(&datasource, id)
+	result := DB.Preload("CrawlConfig").First(&datasource, id)
 	if result.Error != nil {
 		return c.Status(404).JSON(fiber.Map{"error": "Datasource not found"})
 	}
-	return c.JSON(datasource)
+	return c.JSON(datasource.Redacted())
 }
 
 // CreateDatasource creates a new datasource
@@ -35,7 +43,7 @@ func CreateDatasource(c *fiber.Ctx) error {
 	}
 
 	// Validate required fields
-	if datasource.Name == "" || datasource.Type == "" || datasource.ConnectionString == "" {
+	if datasource.Name == "" || datasource.Type == "" || (datasource.ConnectionString == "" && datasource.Type != "static") {
 		return c.Status(400).JSON(fiber.Map{"error": "Missing required fields"})
 	}
 
@@ -49,20 +57,21 @@ func CreateDatasource(c *fiber.Ctx) error {
 	if !isValidDatasourceType(datasource.Type) {
 		return c.Status(400).JSON(fiber.Map{
 			"error": "Invalid datasource type",
-			"valid_types": []string{"sqlite", "mysql", "postgres"},
+			"valid_types": validDatasourceTypes,
 		})
 	}
 
-	// Test connection
+	// Test the connection before creating the datasource
 	if err := testDatasourceConnection(datasource); err != nil {
 		return c.Status(400).JSON(fiber.Map{
 			"error": "Connection test failed",
 			"details": err.Error(),
 		})
 	}
-
-	DB.Create(&datasource)
-	return c.JSON(datasource)
+	if err := DB.Create(datasource).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create datasource"})
+	}
+	return c.JSON(datasource.Redacted())
 }
 
 // UpdateDatasource updates a datasource
@@ -93,18 +102,36 @@ func UpdateDatasource(c *fiber.Ctx) error {
 		if !isValidDatasourceType(updateData.Type) {
 			return c.Status(400).JSON(fiber.Map{
 				"error": "Invalid datasource type",
-				"valid_types": []string{"sqlite", "mysql", "postgres"},
+				"valid_types": validDatasourceTypes,
 			})
 		}
 		datasource.Type = updateData.Type
 	}
 
-	// Validate connection string if changing
-	if updateData.ConnectionString != "" {
+	// Validate connection string if changing. A redacted value sent back unchanged keeps the stored one.
+	if updateData.ConnectionString != "" && updateData.ConnectionString != datasource.Redacted().ConnectionString {
 		datasource.ConnectionString = updateData.ConnectionString
 	}
 
-	// Test connection if any sensitive fields changed
+	// Update pool limits if given
+	if updateData.MaxOpenConns != 0 {
+		datasource.MaxOpenConns = updateData.MaxOpenConns
+	}
+	if updateData.MaxIdleConns != 0 {
+		datasource.MaxIdleConns = updateData.MaxIdleConns
+	}
+
+	// Update crawl settings if given
+	if updateData.CrawlConfig != nil {
+		var crawlConfig models.CrawlConfig
+		DB.Where("datasource_id = ?", datasource.ID).First(&crawlConfig)
+		updateData.CrawlConfig.ID = crawlConfig.ID
+		updateData.CrawlConfig.CreatedAt = crawlConfig.CreatedAt
+		updateData.CrawlConfig.DatasourceID = datasource.ID
+		datasource.CrawlConfig = updateData.CrawlConfig
+	}
+
+	// Test the connection if any sensitive fields changed, keeping the old settings if that fails
 	if updateData.Type != "" || updateData.ConnectionString != "" {
 		if err := testDatasourceConnection(&datasource); err != nil {
 			return c.Status(400).JSON(fiber.Map{
@@ -114,8 +141,22 @@ func UpdateDatasource(c *fiber.Ctx) error {
 		}
 	}
 
-	DB.Save(&datasource)
-	return c.JSON(datasource)
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&datasource).Error; err != nil {
+			return err
+		}
+		if datasource.CrawlConfig != nil {
+			return tx.Save(datasource.CrawlConfig).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update datasource"})
+	}
+
+	// Drop the cached pool so the next run connects with the new settings
+	utils.Pools.Invalidate(datasource.ID)
+	return c.JSON(datasource.Redacted())
 }
 
 // DeleteDatasource deletes a datasource
@@ -137,38 +178,184 @@ func DeleteDatasource(c *fiber.Ctx) error {
 		})
 	}
 
+	DB.Where("datasource_id = ?", datasource.ID).Delete(&models.CrawlConfig{})
+	DB.Unscoped().Where("datasource_id = ?", datasource.ID).Delete(&models.CrawlPage{})
+	DB.Where("datasource_id = ?", datasource.ID).Delete(&models.StaticURL{})
 	DB.Delete(&datasource)
+	utils.Pools.Invalidate(datasource.ID)
 	return c.JSON(fiber.Map{"message": "Datasource deleted"})
 }
 
+// RevealDatasource returns the unredacted connection string of a datasource
+func RevealDatasource(c *fiber.Ctx) error {
+	id := c.Params("id")
+	var datasource models.Datasource
+	result := DB.First(&datasource, id)
+	if result.Error != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Datasource not found"})
+	}
+
+	log.Printf("Connection string of datasource %s revealed to user %d", datasource.Name, currentUserID(c))
+
+	return c.JSON(fiber.Map{"connection_string": datasource.ConnectionString})
+}
+
+// TestDatasource tests the stored connection settings of a datasource
+func TestDatasource(c *fiber.Ctx) error {
+	id := c.Params("id")
+	var datasource models.Datasource
+	result := DB.First(&datasource, id)
+	if result.Error != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Datasource not found"})
+	}
+
+	if err := testDatasourceConnection(&datasource); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Connection test failed",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{"message": "Connection test succeeded"})
+}
+
+// RotateSecretKeys re-encrypts all stored secrets with the active master key
+func RotateSecretKeys(c *fiber.Ctx) error {
+	if !secrets.Enabled() {
+		return c.Status(400).JSON(fiber.Map{"error": "No master key configured"})
+	}
+
+	rotated, err := services.RotateSecretKeys(DB)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Key rotation failed",
+			"details": err.Error(),
+			"rotated": rotated,
+		})
+	}
+	return c.JSON(fiber.Map{"message": "Keys rotated", "rotated": rotated})
+}
+
+// GetDatasourceTables lists the tables of a datasource
+func GetDatasourceTables(c *fiber.Ctx) error {
+	id := c.Params("id")
+	var datasource models.Datasource
+	result := DB.First(&datasource, id)
+	if result.Error != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Datasource not found"})
+	}
+
+	tables, err := services.DatasourceTables(DB, &datasource)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Unable to list tables",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{"dialect": datasource.Type, "tables": tables})
+}
+
+// GetDatasourceColumns describes the columns of a datasource table, with sample values
+func GetDatasourceColumns(c *fiber.Ctx) error {
+	id := c.Params("id")
+	var datasource models.Datasource
+	result := DB.First(&datasource, id)
+	if result.Error != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Datasource not found"})
+	}
+
+	table, err := url.PathUnescape(c.Params("name"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid table name"})
+	}
+
+	samples := c.QueryInt("samples", 3)
+	if samples < 1 || samples > 20 {
+		samples = 3
+	}
+
+	columns, err := services.DatasourceColumns(DB, &datasource, table, samples)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Unable to describe table",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{"dialect": datasource.Type, "table": table, "columns": columns})
+}
+
+// GetDatasourceHealth pings a datasource through its pool and returns the pool statistics
+func GetDatasourceHealth(c *fiber.Ctx) error {
+	id := c.Params("id")
+	var datasource models.Datasource
+	result := DB.First(&datasource, id)
+	if result.Error != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Datasource not found"})
+	}
+
+	if datasource.Type == "crawl" || datasource.Type == "static" {
+		return c.JSON(fiber.Map{"healthy": true, "pooled": false})
+	}
+
+	latency, err := utils.Pools.Ping(&datasource)
+	if err != nil {
+		return c.Status(503).JSON(fiber.Map{
+			"healthy": false,
+			"error":   err.Error(),
+		})
+	}
+
+	stats, _ := utils.Pools.StatsFor(datasource.ID)
+	return c.JSON(fiber.Map{
+		"healthy": true,
+		"pooled":  true,
+		"latency": latency.String(),
+		"pool":    stats,
+	})
+}
+
+// GetDatasourcePoolStats returns the statistics of every open datasource pool
+func GetDatasourcePoolStats(c *fiber.Ctx) error {
+	return c.JSON(utils.Pools.Stats())
+}
+
+var validDatasourceTypes = []string{"sqlite", "mysql", "pgsql", "crawl", "static"}
+
 // Helper function to validate datasource type
 func isValidDatasourceType(dsType string) bool {
-	validTypes := map[string]bool{
-		"sqlite":   true,
-		"mysql":    true,
-		"pgsql": true,
+	for _, validType := range validDatasourceTypes {
+		if dsType == validType {
+			return true
+		}
 	}
-	return validTypes[dsType]
+	return false
 }
 
 // Helper function to test datasource connection
 func testDatasourceConnection(ds *models.Datasource) error {
-	// Test connection using utils package
+	// Crawl datasources have no connection, only seed URLs
+	if ds.Type == "crawl" {
+		_, err := utils.ParseSeedURLs(string(ds.ConnectionString))
+		return err
+	}
+
+	// Static datasources keep their URLs in the app database
+	if ds.Type == "static" {
+		return nil
+	}
+
+	// Ping through a throwaway connection, so the test neither touches the
+	// datasource's pool nor needs the datasource to be saved
 	db, err := utils.ConnectToDatasource(ds)
 	if err != nil {
 		return fmt.Errorf("connection failed: %v", err)
 	}
-	
-	// Verify we can ping the database
 	sqlDB, err := db.DB()
 	if err != nil {
 		return fmt.Errorf("connection pool error: %v", err)
 	}
 	defer sqlDB.Close()
-	
 	if err := sqlDB.Ping(); err != nil {
 		return fmt.Errorf("ping failed: %v", err)
 	}
-	
 	return nil
 }
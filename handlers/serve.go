@@ -0,0 +1,267 @@
+// handlers/serve.go
+package handlers
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sitemap-builder/models"
+	"sitemap-builder/services"
+	"sitemap-builder/storage"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ServeSitemap serves a published sitemap or sitemap index file from the storage
+// backend it was published to, with conditional requests, compression and ranges
+func ServeSitemap(c *fiber.Ctx) error {
+	filename := c.Params("*")
+	storageConfigs, err := services.PublishedFileStorages(DB, filename)
+	if errors.Is(err, storage.ErrNotExist) {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	if err != nil {
+		log.Printf("Error looking up %s: %v", filename, err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+
+	store, info, err := statPublishedFile(c, storageConfigs, filename)
+	if errors.Is(err, storage.ErrNotExist) {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	if err != nil {
+		log.Printf("Error reading %s: %v", filename, err)
+		return c.SendStatus(fiber.StatusServiceUnavailable)
+	}
+
+	// Ranges are only served uncompressed, since they refer to the stored bytes
+	encoding := ""
+	if c.Get(fiber.HeaderRange) == "" {
+		encoding = negotiateEncoding(c.Get(fiber.HeaderAcceptEncoding))
+	}
+
+	etag := info.ETag
+	if etag == "" {
+		etag = fmt.Sprintf("%x-%x", info.LastModified.UnixNano(), info.Size)
+	}
+	if encoding != "" {
+		etag += "-" + encoding
+	}
+	etag = `"` + etag + `"`
+	lastModified := info.LastModified.UTC().Format(http.TimeFormat)
+
+	c.Set(fiber.HeaderContentType, "application/xml")
+	c.Set(fiber.HeaderETag, etag)
+	c.Set(fiber.HeaderLastModified, lastModified)
+	c.Set(fiber.HeaderVary, fiber.HeaderAcceptEncoding)
+	c.Set(fiber.HeaderAcceptRanges, "bytes")
+
+	if notModified(c, etag, info.LastModified) {
+		store.Close()
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+
+	start, length := int64(0), info.Size
+	if rangeHeader := c.Get(fiber.HeaderRange); rangeHeader != "" && ifRangeMatches(c.Get(fiber.HeaderIfRange), etag, lastModified) {
+		if rangeStart, rangeLength, ok := parseRange(rangeHeader, info.Size); ok {
+			if rangeLength == 0 {
+				store.Close()
+				c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes */%d", info.Size))
+				return c.SendStatus(fiber.StatusRequestedRangeNotSatisfiable)
+			}
+			start, length = rangeStart, rangeLength
+			c.Status(fiber.StatusPartialContent)
+			c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, info.Size))
+		}
+	}
+
+	file, err := store.Open(c.UserContext(), filename)
+	if err != nil {
+		store.Close()
+		if errors.Is(err, storage.ErrNotExist) {
+			return c.Status(fiber.StatusNotFound).Send(nil)
+		}
+		log.Printf("Error reading %s: %v", filename, err)
+		return c.Status(fiber.StatusServiceUnavailable).Send(nil)
+	}
+	body := &storedFile{Reader: file, closers: []io.Closer{file, store}}
+
+	if start > 0 {
+		if _, err := io.CopyN(io.Discard, file, start); err != nil {
+			body.Close()
+			log.Printf("Error reading %s: %v", filename, err)
+			return c.Status(fiber.StatusServiceUnavailable).Send(nil)
+		}
+	}
+	if encoding == "" {
+		body.Reader = io.LimitReader(file, length)
+		return c.SendStream(body, int(length))
+	}
+
+	c.Set(fiber.HeaderContentEncoding, encoding)
+	return c.SendStream(compressStream(body, encoding))
+}
+
+// statPublishedFile returns the first storage target the file can be read from,
+// so a file is still served while some targets are unavailable
+func statPublishedFile(c *fiber.Ctx, storageConfigs []models.StorageConfig, filename string) (storage.Storage, storage.ObjectInfo, error) {
+	err := storage.ErrNotExist
+	for _, storageConfig := range storageConfigs {
+		store, openErr := storage.Open(storageConfig)
+		if openErr != nil {
+			err = openErr
+			continue
+		}
+
+		info, statErr := store.Stat(c.UserContext(), filename)
+		if statErr == nil {
+			return store, info, nil
+		}
+		store.Close()
+		if !errors.Is(statErr, storage.ErrNotExist) {
+			err = statErr
+		}
+	}
+	return nil, storage.ObjectInfo{}, err
+}
+
+// storedFile is a file read from a storage backend, closing the backend with it
+type storedFile struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (f *storedFile) Close() error {
+	var err error
+	for _, closer := range f.closers {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// compressStream compresses r on the fly; the returned reader closes r once
+// it is closed or fully read
+func compressStream(r io.ReadCloser, encoding string) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		defer r.Close()
+
+		var w io.WriteCloser
+		if encoding == "br" {
+			w = brotli.NewWriter(pw)
+		} else {
+			w = gzip.NewWriter(pw)
+		}
+		_, err := io.Copy(w, r)
+		if closeErr := w.Close(); err == nil {
+			err = closeErr
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// negotiateEncoding picks brotli or gzip from an Accept-Encoding header, or ""
+// for an uncompressed response
+func negotiateEncoding(acceptEncoding string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		accepted[strings.ToLower(strings.TrimSpace(coding))] = q > 0
+	}
+
+	for _, encoding := range []string{"br", "gzip"} {
+		if accepted[encoding] {
+			return encoding
+		}
+	}
+	return ""
+}
+
+// notModified evaluates If-None-Match, or If-Modified-Since when that is absent
+func notModified(c *fiber.Ctx, etag string, modified time.Time) bool {
+	if ifNoneMatch := c.Get(fiber.HeaderIfNoneMatch); ifNoneMatch != "" {
+		for _, tag := range strings.Split(ifNoneMatch, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	if since, err := http.ParseTime(c.Get(fiber.HeaderIfModifiedSince)); err == nil {
+		return !modified.Truncate(time.Second).After(since)
+	}
+	return false
+}
+
+// ifRangeMatches reports whether a Range header applies given the If-Range
+// header, which must match the current ETag (strongly) or Last-Modified date
+func ifRangeMatches(ifRange, etag, lastModified string) bool {
+	if ifRange == "" {
+		return true
+	}
+	return ifRange == etag || ifRange == lastModified
+}
+
+// parseRange parses a single byte range. ok is false for headers that should be
+// ignored (malformed or multiple ranges); an unsatisfiable range has length 0.
+func parseRange(header string, size int64) (start, length int64, ok bool) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false
+	}
+
+	if first == "" {
+		// Suffix range: the last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, false
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, true
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, true
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, false
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	if start >= size {
+		return 0, 0, true
+	}
+	return start, end - start + 1, true
+}
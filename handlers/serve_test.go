@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sitemap-builder/models"
+	"sitemap-builder/storage"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+const servedIndex = `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap>
+    <loc>https://example.com/sitemaps/a/blog/blog-0001.xml</loc>
+  </sitemap>
+</sitemapindex>`
+
+// newServeApp serves the files of a sitemap index "a" published to a memory backend
+func newServeApp(t *testing.T) *fiber.App {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.SitemapIndex{}, &models.StorageConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	previous := DB
+	SetDB(db)
+	t.Cleanup(func() { SetDB(previous) })
+
+	index := models.SitemapIndex{Name: "a", StorageConfigs: []models.StorageConfig{{Mode: "memory"}}}
+	if err := db.Create(&index).Error; err != nil {
+		t.Fatal(err)
+	}
+	store := storage.MemoryStore(index.StorageConfigs[0].ID)
+	for key, content := range map[string]string{"a.xml": servedIndex, "a/blog/blog-0001.xml": "<urlset/>"} {
+		if err := store.Put(context.Background(), key, strings.NewReader(content), storage.PutOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	app := fiber.New()
+	app.Get("/sitemaps/*", ServeSitemap)
+	return app
+}
+
+func serve(t *testing.T, app *fiber.App, path string, headers map[string]string) (*http.Response, string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading %s: %v", path, err)
+	}
+	return resp, string(body)
+}
+
+func TestServeSitemapPaths(t *testing.T) {
+	app := newServeApp(t)
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/sitemaps/a.xml", http.StatusOK, servedIndex},
+		{"/sitemaps/a/blog/blog-0001.xml", http.StatusOK, "<urlset/>"},
+		{"/sitemaps/b.xml", http.StatusNotFound, ""},
+		{"/sitemaps/a/blog/blog-0002.xml", http.StatusNotFound, ""},
+		{"/sitemaps/a/blog-0001.xml", http.StatusNotFound, ""},
+		{"/sitemaps/a/blog/../a.xml", http.StatusNotFound, ""},
+		{"/sitemaps/a/blog/blog-0001.txt", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		resp, body := serve(t, app, tt.path, nil)
+		if resp.StatusCode != tt.status {
+			t.Errorf("GET %s = %d, want %d", tt.path, resp.StatusCode, tt.status)
+		}
+		if tt.status == http.StatusOK {
+			if body != tt.body {
+				t.Errorf("GET %s body = %q, want %q", tt.path, body, tt.body)
+			}
+			if got := resp.Header.Get("Content-Type"); got != "application/xml" {
+				t.Errorf("GET %s Content-Type = %q", tt.path, got)
+			}
+		}
+	}
+}
+
+func TestServeSitemapConditional(t *testing.T) {
+	app := newServeApp(t)
+	sum := md5.Sum([]byte(servedIndex))
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+
+	resp, _ := serve(t, app, "/sitemaps/a.xml", nil)
+	if got := resp.Header.Get("ETag"); got != etag {
+		t.Fatalf("ETag = %s, want %s", got, etag)
+	}
+	lastModified := resp.Header.Get("Last-Modified")
+	if _, err := http.ParseTime(lastModified); err != nil {
+		t.Fatalf("Last-Modified %q: %v", lastModified, err)
+	}
+
+	past := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	future := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	tests := []struct {
+		name    string
+		headers map[string]string
+		status  int
+	}{
+		{"matching etag", map[string]string{"If-None-Match": etag}, http.StatusNotModified},
+		{"weak etag", map[string]string{"If-None-Match": "W/" + etag}, http.StatusNotModified},
+		{"etag in a list", map[string]string{"If-None-Match": `"other", ` + etag}, http.StatusNotModified},
+		{"any etag", map[string]string{"If-None-Match": "*"}, http.StatusNotModified},
+		{"other etag", map[string]string{"If-None-Match": `"other"`}, http.StatusOK},
+		{"etag of the uncompressed file", map[string]string{"If-None-Match": etag, "Accept-Encoding": "gzip"}, http.StatusOK},
+		{"etag of the gzipped file", map[string]string{"If-None-Match": strings.TrimSuffix(etag, `"`) + `-gzip"`, "Accept-Encoding": "gzip"}, http.StatusNotModified},
+		{"not modified since", map[string]string{"If-Modified-Since": lastModified}, http.StatusNotModified},
+		{"modified since", map[string]string{"If-Modified-Since": past}, http.StatusOK},
+		{"not modified since a later date", map[string]string{"If-Modified-Since": future}, http.StatusNotModified},
+		{"etag takes precedence", map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": future}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := serve(t, app, "/sitemaps/a.xml", tt.headers)
+			if resp.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if tt.status == http.StatusNotModified && body != "" {
+				t.Errorf("304 response has a body: %q", body)
+			}
+		})
+	}
+}
+
+func TestServeSitemapRanges(t *testing.T) {
+	app := newServeApp(t)
+	size := len(servedIndex)
+	sum := md5.Sum([]byte(servedIndex))
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+
+	tests := []struct {
+		name         string
+		headers      map[string]string
+		status       int
+		body         string
+		contentRange string
+	}{
+		{"first bytes", map[string]string{"Range": "bytes=0-4"}, http.StatusPartialContent, servedIndex[:5], fmt.Sprintf("bytes 0-4/%d", size)},
+		{"open ended", map[string]string{"Range": "bytes=10-"}, http.StatusPartialContent, servedIndex[10:], fmt.Sprintf("bytes 10-%d/%d", size-1, size)},
+		{"suffix", map[string]string{"Range": "bytes=-16"}, http.StatusPartialContent, servedIndex[size-16:], fmt.Sprintf("bytes %d-%d/%d", size-16, size-1, size)},
+		{"end past the file", map[string]string{"Range": fmt.Sprintf("bytes=%d-%d", size-3, size+100)}, http.StatusPartialContent, servedIndex[size-3:], fmt.Sprintf("bytes %d-%d/%d", size-3, size-1, size)},
+		{"start past the file", map[string]string{"Range": fmt.Sprintf("bytes=%d-", size)}, http.StatusRequestedRangeNotSatisfiable, "", fmt.Sprintf("bytes */%d", size)},
+		{"empty suffix", map[string]string{"Range": "bytes=-0"}, http.StatusRequestedRangeNotSatisfiable, "", fmt.Sprintf("bytes */%d", size)},
+		{"multiple ranges are ignored", map[string]string{"Range": "bytes=0-1,4-5"}, http.StatusOK, servedIndex, ""},
+		{"malformed range is ignored", map[string]string{"Range": "bytes=5-2"}, http.StatusOK, servedIndex, ""},
+		{"other unit is ignored", map[string]string{"Range": "items=0-1"}, http.StatusOK, servedIndex, ""},
+		{"matching If-Range", map[string]string{"Range": "bytes=0-4", "If-Range": etag}, http.StatusPartialContent, servedIndex[:5], fmt.Sprintf("bytes 0-4/%d", size)},
+		{"stale If-Range", map[string]string{"Range": "bytes=0-4", "If-Range": `"other"`}, http.StatusOK, servedIndex, ""},
+		{"ranges are served uncompressed", map[string]string{"Range": "bytes=0-4", "Accept-Encoding": "gzip, br"}, http.StatusPartialContent, servedIndex[:5], fmt.Sprintf("bytes 0-4/%d", size)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := serve(t, app, "/sitemaps/a.xml", tt.headers)
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if tt.status != http.StatusRequestedRangeNotSatisfiable && body != tt.body {
+				t.Errorf("body = %q, want %q", body, tt.body)
+			}
+			if got := resp.Header.Get("Content-Range"); got != tt.contentRange {
+				t.Errorf("Content-Range = %q, want %q", got, tt.contentRange)
+			}
+			if got := resp.Header.Get("Content-Encoding"); got != "" {
+				t.Errorf("Content-Encoding = %q, want none", got)
+			}
+		})
+	}
+}
+
+func TestServeSitemapCompression(t *testing.T) {
+	app := newServeApp(t)
+
+	tests := []struct {
+		acceptEncoding string
+		encoding       string
+	}{
+		{"gzip", "gzip"},
+		{"gzip, deflate, br", "br"},
+		{"br;q=0, gzip", "gzip"},
+		{"deflate", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			resp, body := serve(t, app, "/sitemaps/a.xml", map[string]string{"Accept-Encoding": tt.acceptEncoding})
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status = %d", resp.StatusCode)
+			}
+			if got := resp.Header.Get("Content-Encoding"); got != tt.encoding {
+				t.Fatalf("Content-Encoding = %q, want %q", got, tt.encoding)
+			}
+			if got := resp.Header.Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Vary = %q", got)
+			}
+
+			var r io.Reader = strings.NewReader(body)
+			switch tt.encoding {
+			case "gzip":
+				gz, err := gzip.NewReader(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				r = gz
+			case "br":
+				r = brotli.NewReader(r)
+			}
+			decoded, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decoded, []byte(servedIndex)) {
+				t.Errorf("decoded body = %q, want the index file", decoded)
+			}
+		})
+	}
+}
@@ -3,6 +3,8 @@ package handlers
 
 import (
 	"sitemap-builder/models"
+	"sitemap-builder/services"
+	"sitemap-builder/utils"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -32,11 +34,18 @@ func CreateSitemap(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
 	}
 
+	if !utils.IsSafeFileName(sitemap.Name) {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid sitemap name"})
+	}
+
 	// Verify the associated sitemap index exists
 	var index models.SitemapIndex
 	if result := DB.First(&index, sitemap.SitemapIndexID); result.Error != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid SitemapIndexID"})
 	}
+	if sitemapNameTaken(sitemap.Name, sitemap.SitemapIndexID, 0) {
+		return c.Status(409).JSON(fiber.Map{"error": "The sitemap index already has a sitemap with this name"})
+	}
 
 	DB.Create(&sitemap)
 	return c.JSON(sitemap)
@@ -63,11 +72,27 @@ func UpdateSitemap(c *fiber.Ctx) error {
 		sitemap.SitemapIndexID = currentSitemapIndexID
 	}
 
+	if !utils.IsSafeFileName(sitemap.Name) {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid sitemap name"})
+	}
+	if sitemapNameTaken(sitemap.Name, sitemap.SitemapIndexID, sitemap.ID) {
+		return c.Status(409).JSON(fiber.Map{"error": "The sitemap index already has a sitemap with this name"})
+	}
+
 	DB.Save(&sitemap)
 	return c.JSON(sitemap)
 }
 
-// DeleteSitemap deletes a sitemap
+// sitemapNameTaken reports whether another sitemap of the index has the name,
+// which would share its storage directory
+func sitemapNameTaken(name string, sitemapIndexID, id uint) bool {
+	var count int64
+	DB.Model(&models.Sitemap{}).Where("name = ? AND sitemap_index_id = ? AND id <> ?", name, sitemapIndexID, id).Count(&count)
+	return count > 0
+}
+
+// DeleteSitemap deletes a sitemap, purging its published files and republishing
+// its index unless ?purge=false is given
 func DeleteSitemap(c *fiber.Ctx) error {
 	id := c.Params("id")
 	var sitemap models.Sitemap
@@ -82,5 +107,15 @@ func DeleteSitemap(c *fiber.Ctx) error {
 	}
 
 	DB.Delete(&sitemap)
-	return c.JSON(fiber.Map{"message": "Sitemap deleted"})
+
+	// Published files are purged unless ?purge=false
+	if !c.QueryBool("purge", true) {
+		return c.JSON(fiber.Map{"message": "Sitemap deleted"})
+	}
+	purged, err := services.PurgeSitemap(DB, &sitemap)
+	response := fiber.Map{"message": "Sitemap deleted", "purged_files": purged}
+	if err != nil {
+		response["purge_error"] = err.Error()
+	}
+	return c.JSON(response)
 }
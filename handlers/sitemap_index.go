@@ -3,6 +3,8 @@ package handlers
 
 import (
 	"sitemap-builder/models"
+	"sitemap-builder/services"
+	"sitemap-builder/utils"
 
 	"github.com/gofiber/fiber/v2"
 	"gorm.io/gorm"
@@ -38,10 +40,73 @@ func CreateSitemapIndex(c *fiber.Ctx) error {
 	if err := c.BodyParser(sitemapIndex); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
 	}
+	if !utils.IsSafeFileName(sitemapIndex.Name) {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid sitemap index name"})
+	}
+	if !isValidFailurePolicy(sitemapIndex.FailurePolicy) {
+		return c.Status(400).JSON(fiber.Map{"error": "failure_policy must be \"fail\" or \"degrade\""})
+	}
+	for _, storageConfig := range sitemapIndex.StorageConfigs {
+		if !isValidVerifyMode(storageConfig.Verify) {
+			return c.Status(400).JSON(fiber.Map{"error": "verify must be \"checksum\", \"readback\" or empty"})
+		}
+		if storageConfig.PublicURL != "" && !utils.IsAbsoluteHTTPURL(storageConfig.PublicURL) {
+			return c.Status(400).JSON(fiber.Map{"error": "public_url must be an absolute http(s) URL"})
+		}
+	}
+	if sitemapIndexNameTaken(sitemapIndex.Name, sitemapIndex.ID) {
+		return c.Status(409).JSON(fiber.Map{"error": "A sitemap index with this name already exists"})
+	}
 	DB.Create(&sitemapIndex)
+	redactStorageConfigs(sitemapIndex)
 	return c.JSON(sitemapIndex)
 }
 
+// redactStorageConfigs hides the secrets of a sitemap index's storage targets
+func redactStorageConfigs(sitemapIndex *models.SitemapIndex) {
+	for i := range sitemapIndex.StorageConfigs {
+		sitemapIndex.StorageConfigs[i] = sitemapIndex.StorageConfigs[i].Redacted()
+	}
+}
+
+// keepStoredSecrets keeps the stored secrets of the storage targets that were
+// sent back with a secret still redacted
+func keepStoredSecrets(storageConfigs []models.StorageConfig, stored []models.StorageConfig) {
+	for i := range storageConfigs {
+		for _, old := range stored {
+			if old.ID == 0 || old.ID != storageConfigs[i].ID {
+				continue
+			}
+			redacted := old.Redacted()
+			if storageConfigs[i].SecretAccessKey == redacted.SecretAccessKey {
+				storageConfigs[i].SecretAccessKey = old.SecretAccessKey
+			}
+			if storageConfigs[i].Password == redacted.Password {
+				storageConfigs[i].Password = old.Password
+			}
+			if storageConfigs[i].PrivateKey == redacted.PrivateKey {
+				storageConfigs[i].PrivateKey = old.PrivateKey
+			}
+		}
+	}
+}
+
+// sitemapIndexNameTaken reports whether another sitemap index has the name. Names
+// are unique since published files are stored and served under them.
+func sitemapIndexNameTaken(name string, id uint) bool {
+	var count int64
+	DB.Model(&models.SitemapIndex{}).Where("name = ? AND id <> ?", name, id).Count(&count)
+	return count > 0
+}
+
+func isValidFailurePolicy(policy string) bool {
+	return policy == "" || policy == "fail" || policy == "degrade"
+}
+
+func isValidVerifyMode(mode string) bool {
+	return mode == "" || mode == "checksum" || mode == "readback"
+}
+
 // UpdateSitemapIndex updates a sitemap index
 func UpdateSitemapIndex(c *fiber.Ctx) error {
 	id := c.Params("id")
@@ -50,16 +115,39 @@ func UpdateSitemapIndex(c *fiber.Ctx) error {
 	if result.Error != nil {
 		return c.Status(404).JSON(fiber.Map{"error": "SitemapIndex not found"})
 	}
+	var storedConfigs []models.StorageConfig
+	DB.Where("sitemap_index_id = ?", sitemapIndex.ID).Find(&storedConfigs)
 	
 	if err := c.BodyParser(&sitemapIndex); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
 	}
+	// A redacted secret sent back unchanged keeps the stored one
+	keepStoredSecrets(sitemapIndex.StorageConfigs, storedConfigs)
+	if !utils.IsSafeFileName(sitemapIndex.Name) {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid sitemap index name"})
+	}
+	if !isValidFailurePolicy(sitemapIndex.FailurePolicy) {
+		return c.Status(400).JSON(fiber.Map{"error": "failure_policy must be \"fail\" or \"degrade\""})
+	}
+	for _, storageConfig := range sitemapIndex.StorageConfigs {
+		if !isValidVerifyMode(storageConfig.Verify) {
+			return c.Status(400).JSON(fiber.Map{"error": "verify must be \"checksum\", \"readback\" or empty"})
+		}
+		if storageConfig.PublicURL != "" && !utils.IsAbsoluteHTTPURL(storageConfig.PublicURL) {
+			return c.Status(400).JSON(fiber.Map{"error": "public_url must be an absolute http(s) URL"})
+		}
+	}
+	if sitemapIndexNameTaken(sitemapIndex.Name, sitemapIndex.ID) {
+		return c.Status(409).JSON(fiber.Map{"error": "A sitemap index with this name already exists"})
+	}
 	
 	DB.Save(&sitemapIndex)
+	redactStorageConfigs(&sitemapIndex)
 	return c.JSON(sitemapIndex)
 }
 
-// DeleteSitemapIndex deletes a sitemap index
+// DeleteSitemapIndex deletes a sitemap index, purging its published files
+// from every storage target unless ?purge=false is given
 func DeleteSitemapIndex(c *fiber.Ctx) error {
 	id := c.Params("id")
 	var sitemapIndex models.SitemapIndex
@@ -67,7 +155,35 @@ func DeleteSitemapIndex(c *fiber.Ctx) error {
 	if result.Error != nil {
 		return c.Status(404).JSON(fiber.Map{"error": "SitemapIndex not found"})
 	}
-	
+
+	response := fiber.Map{"message": "SitemapIndex deleted"}
+	if c.QueryBool("purge", true) {
+		purged, err := services.PurgeSitemapIndex(DB, &sitemapIndex)
+		response["purged_files"] = purged
+		if err != nil {
+			response["purge_error"] = err.Error()
+		}
+	}
+
 	DB.Delete(&sitemapIndex)
-	return c.JSON(fiber.Map{"message": "SitemapIndex deleted"})
+	return c.JSON(response)
+}
+
+// GetGenerationRuns returns the latest generation runs of a sitemap index with
+// the outcome per storage target
+func GetGenerationRuns(c *fiber.Ctx) error {
+	id := c.Params("id")
+	var sitemapIndex models.SitemapIndex
+	if result := DB.First(&sitemapIndex, id); result.Error != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "SitemapIndex not found"})
+	}
+
+	limit := c.QueryInt("limit", 20)
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	var runs []models.GenerationRun
+	DB.Preload("Targets").Where("sitemap_index_id = ?", sitemapIndex.ID).Order("id desc").Limit(limit).Find(&runs)
+	return c.JSON(runs)
 }
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"sitemap-builder/models"
+	"sitemap-builder/secrets"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestKeepStoredSecrets(t *testing.T) {
+	stored := []models.StorageConfig{
+		{Model: gorm.Model{ID: 1}, Mode: "s3", SecretAccessKey: "s3-secret"},
+		{Model: gorm.Model{ID: 2}, Mode: "sftp", Password: "sftp-password", PrivateKey: "env:SFTP_KEY"},
+	}
+	sent := []models.StorageConfig{
+		{Model: gorm.Model{ID: 1}, Mode: "s3", SecretAccessKey: secrets.Redacted},
+		{Model: gorm.Model{ID: 2}, Mode: "sftp", Password: "new-password", PrivateKey: "env:SFTP_KEY"},
+		{Mode: "webdav", Password: secrets.Redacted},
+	}
+	keepStoredSecrets(sent, stored)
+
+	if sent[0].SecretAccessKey != "s3-secret" {
+		t.Errorf("redacted secret access key became %q, want the stored one", sent[0].SecretAccessKey)
+	}
+	if sent[1].Password != "new-password" {
+		t.Errorf("changed password became %q, want the new one", sent[1].Password)
+	}
+	if sent[1].PrivateKey != "env:SFTP_KEY" {
+		t.Errorf("secret reference became %q", sent[1].PrivateKey)
+	}
+	if sent[2].Password != secrets.Redacted {
+		t.Errorf("new target got password %q from another target", sent[2].Password)
+	}
+}
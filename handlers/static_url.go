@@ -0,0 +1,225 @@
+// handlers/static_url.go
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sitemap-builder/models"
+	"sitemap-builder/utils"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// GetStaticURLs returns all URLs of a static datasource
+func GetStaticURLs(c *fiber.Ctx) error {
+	id := c.Params("id")
+	var datasource models.Datasource
+	if result := DB.First(&datasource, id); result.Error != nil || datasource.Type != "static" {
+		return c.Status(404).JSON(fiber.Map{"error": "Static datasource not found"})
+	}
+
+	var staticURLs []models.StaticURL
+	DB.Where("datasource_id = ?", datasource.ID).Order("id").Find(&staticURLs)
+	return c.JSON(staticURLs)
+}
+
+// CreateStaticURL adds a URL to a static datasource
+func CreateStaticURL(c *fiber.Ctx) error {
+	id := c.Params("id")
+	var datasource models.Datasource
+	if result := DB.First(&datasource, id); result.Error != nil || datasource.Type != "static" {
+		return c.Status(404).JSON(fiber.Map{"error": "Static datasource not found"})
+	}
+
+	staticURL := new(models.StaticURL)
+	if err := c.BodyParser(staticURL); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if err := validateStaticURL(staticURL); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	staticURL.DatasourceID = datasource.ID
+	DB.Create(&staticURL)
+	return c.JSON(staticURL)
+}
+
+// UpdateStaticURL updates a URL of a static datasource
+func UpdateStaticURL(c *fiber.Ctx) error {
+	var staticURL models.StaticURL
+	result := DB.Where("datasource_id = ?", c.Params("id")).First(&staticURL, c.Params("urlId"))
+	if result.Error != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Static URL not found"})
+	}
+
+	updateData := new(models.StaticURL)
+	if err := c.BodyParser(updateData); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	if updateData.Loc != "" {
+		staticURL.Loc = updateData.Loc
+	}
+	if updateData.LastMod != "" {
+		staticURL.LastMod = updateData.LastMod
+	}
+	if updateData.Priority != 0 {
+		staticURL.Priority = updateData.Priority
+	}
+	if updateData.ChangeFreq != "" {
+		staticURL.ChangeFreq = updateData.ChangeFreq
+	}
+	if err := validateStaticURL(&staticURL); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	DB.Save(&staticURL)
+	return c.JSON(staticURL)
+}
+
+// DeleteStaticURL removes a URL from a static datasource
+func DeleteStaticURL(c *fiber.Ctx) error {
+	var staticURL models.StaticURL
+	result := DB.Where("datasource_id = ?", c.Params("id")).First(&staticURL, c.Params("urlId"))
+	if result.Error != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Static URL not found"})
+	}
+
+	DB.Delete(&staticURL)
+	return c.JSON(fiber.Map{"message": "Static URL deleted"})
+}
+
+// ImportStaticURLs bulk imports URLs into a static datasource from a JSON array
+// or, with a text/csv content type, a CSV file with a loc,lastmod,priority,changefreq header.
+// With ?replace=true the existing URLs are removed first.
+func ImportStaticURLs(c *fiber.Ctx) error {
+	id := c.Params("id")
+	var datasource models.Datasource
+	if result := DB.First(&datasource, id); result.Error != nil || datasource.Type != "static" {
+		return c.Status(404).JSON(fiber.Map{"error": "Static datasource not found"})
+	}
+
+	var staticURLs []models.StaticURL
+	if strings.HasPrefix(c.Get(fiber.HeaderContentType), "text/csv") {
+		parsed, err := parseStaticURLsCSV(bytes.NewReader(c.Body()))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid CSV", "details": err.Error()})
+		}
+		staticURLs = parsed
+	} else if err := c.BodyParser(&staticURLs); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	if len(staticURLs) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "No URLs to import"})
+	}
+
+	// Reject the whole import if any row is invalid
+	var invalid []fiber.Map
+	for i := range staticURLs {
+		if err := validateStaticURL(&staticURLs[i]); err != nil {
+			invalid = append(invalid, fiber.Map{"row": i + 1, "loc": staticURLs[i].Loc, "error": err.Error()})
+		}
+		staticURLs[i].ID = 0
+		staticURLs[i].DatasourceID = datasource.ID
+	}
+	if len(invalid) > 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid URLs", "invalid": invalid})
+	}
+
+	replace := c.QueryBool("replace")
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if replace {
+			if err := tx.Where("datasource_id = ?", datasource.ID).Delete(&models.StaticURL{}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.CreateInBatches(&staticURLs, 500).Error
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Import failed", "details": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"message":  "URLs imported",
+		"imported": len(staticURLs),
+		"replaced": replace,
+	})
+}
+
+// validateStaticURL checks a static URL's fields against the sitemap protocol
+func validateStaticURL(staticURL *models.StaticURL) error {
+	staticURL.Loc = strings.TrimSpace(staticURL.Loc)
+	if staticURL.Loc == "" {
+		return fmt.Errorf("loc is required")
+	}
+	if !utils.IsAbsoluteHTTPURL(staticURL.Loc) {
+		return fmt.Errorf("loc %q is not an absolute http or https URL", staticURL.Loc)
+	}
+	if staticURL.LastMod != "" {
+		if _, ok := utils.ParseDate(staticURL.LastMod); !ok {
+			return fmt.Errorf("invalid lastmod %q", staticURL.LastMod)
+		}
+	}
+	if staticURL.Priority < 0 || staticURL.Priority > 1 {
+		return fmt.Errorf("priority must be between 0 and 1")
+	}
+	if staticURL.ChangeFreq != "" && !utils.IsValidChangeFreq(staticURL.ChangeFreq) {
+		return fmt.Errorf("invalid changefreq %q", staticURL.ChangeFreq)
+	}
+	return nil
+}
+
+// parseStaticURLsCSV reads static URLs from CSV, using the header row to find the columns
+func parseStaticURLsCSV(r io.Reader) ([]models.StaticURL, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["loc"]; !ok {
+		return nil, fmt.Errorf("missing loc column")
+	}
+
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var staticURLs []models.StaticURL
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		staticURL := models.StaticURL{
+			Loc:        field(record, "loc"),
+			LastMod:    field(record, "lastmod"),
+			ChangeFreq: field(record, "changefreq"),
+		}
+		if priority := field(record, "priority"); priority != "" {
+			if staticURL.Priority, err = strconv.ParseFloat(priority, 64); err != nil {
+				return nil, fmt.Errorf("invalid priority %q", priority)
+			}
+		}
+		staticURLs = append(staticURLs, staticURL)
+	}
+	return staticURLs, nil
+}
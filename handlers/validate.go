@@ -1,265 +1,325 @@
+// handlers/validate.go
 package handlers
 
 import (
 	"encoding/csv"
 	"fmt"
-	"github.com/gofiber/fiber/v2"
-	"net/http"
 	"os"
-	"path/filepath"
 	"sitemap-builder/models"
-	"sync"
-	"time"
-	"encoding/xml"
-	"io/ioutil"
+	"sitemap-builder/services"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
 )
 
-// StartValidation initiates sitemap validation and returns a link to results
+// StartValidation initiates sitemap validation and returns links to its summary and results
 func StartValidation(c *fiber.Ctx) error {
 	type ValidateRequest struct {
-		IndexURL string `query:"index_url"`
+		IndexURL string                   `query:"index_url" json:"index_url"`
+		Options  models.ValidationOptions `json:"options"`
 	}
-	
+
 	req := new(ValidateRequest)
 	if err := c.QueryParser(req); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
 	}
-	
-	// Generate unique ID for this validation job
-	jobID := fmt.Sprintf("validation_%d", time.Now().Unix())
-	resultsFile := filepath.Join("data/validations", jobID + ".csv")
-	
-	// Create directory if it doesn't exist
-	os.MkdirAll("data/validations", os.ModePerm)
-	
-	// Create CSV file with headers
-	file, err := os.Create(resultsFile)
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+	}
+	if req.IndexURL == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "index_url is required"})
+	}
+	if err := services.NormalizeValidationOptions(&req.Options); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid options", "details": err.Error()})
+	}
+
+	job, err := services.StartValidation(DB, req.IndexURL, req.Options, currentUserID(c))
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to create results file"})
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to start validation", "details": err.Error()})
 	}
-	defer file.Close()
-	
-	writer := csv.NewWriter(file)
-	writer.Write([]string{"URL", "Status", "StatusCode", "Error"})
-	writer.Flush()
-	
-	// Start validation in background
-	go validateSitemapIndexAsync(req.IndexURL, resultsFile)
-	
-	// Return link to results file
+
 	return c.JSON(fiber.Map{
-		"message": "Validation started",
-		"job_id": jobID,
-		"results_url": fmt.Sprintf("/api/validation/results/%s", jobID),
+		"message":     "Validation started",
+		"job_id":      job.JobID,
+		"summary_url": fmt.Sprintf("/api/validation/jobs/%s", job.JobID),
+		"results_url": fmt.Sprintf("/api/validation/%s/results", job.JobID),
 	})
 }
 
-// GetValidationResults returns the current validation results
-func GetValidationResults(c *fiber.Ctx) error {
-	jobID := c.Params("id")
-	resultsFile := filepath.Join("data/validations", jobID + ".csv")
-	
-	// Check if file exists
-	if _, err := os.Stat(resultsFile); os.IsNotExist(err) {
+// ValidateSitemapIndex validates the generated files of a sitemap index, read from
+// its storage instead of over HTTP. URLs are only checked with "check_urls": true.
+func ValidateSitemapIndex(c *fiber.Ctx) error {
+	var sitemapIndex models.SitemapIndex
+	if result := DB.Preload("StorageConfigs").First(&sitemapIndex, c.Params("id")); result.Error != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "SitemapIndex not found"})
+	}
+
+	type ValidateIndexRequest struct {
+		StorageConfigID uint                     `json:"storage_config_id"`
+		CheckURLs       bool                     `json:"check_urls"`
+		Options         models.ValidationOptions `json:"options"`
+	}
+	req := new(ValidateIndexRequest)
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+	}
+	req.Options.SkipURLChecks = !req.CheckURLs
+	if err := services.NormalizeValidationOptions(&req.Options); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid options", "details": err.Error()})
+	}
+
+	job, err := services.StartIndexValidation(DB, &sitemapIndex, req.StorageConfigID, req.Options, currentUserID(c))
+	if err == services.ErrUnknownStorageConfig {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to start validation", "details": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"message":     "Validation started",
+		"job_id":      job.JobID,
+		"summary_url": fmt.Sprintf("/api/validation/jobs/%s", job.JobID),
+		"results_url": fmt.Sprintf("/api/validation/%s/results", job.JobID),
+	})
+}
+
+// GetValidationJobs lists validation jobs, newest first (?state=, ?sitemap_index_id=, ?limit=50, ?offset=0)
+func GetValidationJobs(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 50)
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+
+	query := DB.Order("id desc").Limit(limit).Offset(c.QueryInt("offset", 0))
+	if state := c.Query("state"); state != "" {
+		query = query.Where("state = ?", state)
+	}
+	if sitemapIndexID := c.QueryInt("sitemap_index_id"); sitemapIndexID > 0 {
+		query = query.Where("sitemap_index_id = ?", sitemapIndexID)
+	}
+
+	var jobs []models.ValidationJob
+	query.Find(&jobs)
+	for i := range jobs {
+		jobs[i].Options = jobs[i].Options.Redacted()
+	}
+	return c.JSON(jobs)
+}
+
+// GetValidationJob returns the summary of a validation job, which is kept up to date while it runs
+func GetValidationJob(c *fiber.Ctx) error {
+	var job models.ValidationJob
+	if result := DB.Where("job_id = ?", c.Params("id")).First(&job); result.Error != nil {
 		return c.Status(404).JSON(fiber.Map{"error": "Validation job not found"})
 	}
-	
-	// Return file as download
-	return c.Download(resultsFile)
+	job.Options = job.Options.Redacted()
+	return c.JSON(job)
 }
 
-// Background validation process
-func validateSitemapIndexAsync(sitemapURL, resultsFile string) {
-	// Open CSV file for appending
-	file, err := os.OpenFile(resultsFile, os.O_APPEND|os.O_WRONLY, 0644)
-	if err != nil {
-		return
+// SetValidationBaseline marks a finished validation job as the baseline of its sitemap index or target
+func SetValidationBaseline(c *fiber.Ctx) error {
+	var job models.ValidationJob
+	if result := DB.Where("job_id = ?", c.Params("id")).First(&job); result.Error != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Validation job not found"})
+	}
+	if job.State != "done" {
+		return c.Status(400).JSON(fiber.Map{"error": "Only finished validation jobs can be a baseline"})
 	}
-	defer file.Close()
 
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
+	if err := services.SetValidationBaseline(DB, &job); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to set baseline", "details": err.Error()})
+	}
+	job.Options = job.Options.Redacted()
+	return c.JSON(job)
+}
 
-	// Create mutex for safe writing to CSV
-	var mutex sync.Mutex
+// CompareValidationJobs lists what changed from the ?base job to the ?head job: newly
+// failing and recovered URLs, status code changes and added or removed entries. Without
+// ?base the head is compared with the baseline of its sitemap index or target.
+func CompareValidationJobs(c *fiber.Ctx) error {
+	var head models.ValidationJob
+	if result := DB.Where("job_id = ?", c.Query("head")).First(&head); result.Error != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Head validation job not found"})
+	}
 
-	// Fetch sitemap content
-	content, err := fetchURL(sitemapURL)
-	if err != nil {
-		mutex.Lock()
-		writer.Write([]string{sitemapURL, "ERROR", "0", err.Error()})
-		writer.Flush()
-		mutex.Unlock()
-		return
-	}
-
-	// First, try to parse as sitemap index
-	var index models.XMLSitemapIndex
-	if err := xml.Unmarshal(content, &index); err == nil && len(index.Sitemaps) > 0 {
-		// Record success for the index
-		mutex.Lock()
-		writer.Write([]string{sitemapURL, "OK", "200", ""})
-		writer.Flush()
-		mutex.Unlock()
-
-		// Process each sitemap within the index with limited concurrency
-		var wg sync.WaitGroup
-		semaphore := make(chan struct{}, 5) // Limit to 5 concurrent requests
-
-		for _, sitemap := range index.Sitemaps {
-			wg.Add(1)
-			semaphore <- struct{}{} // Acquire semaphore
-
-			go func(sitemapURL string) {
-				defer wg.Done()
-				defer func() { <-semaphore }() // Release semaphore
-
-				validateSitemap(sitemapURL, writer, &mutex)
-			}(sitemap.Loc)
+	var base *models.ValidationJob
+	if c.Query("base") != "" {
+		base = new(models.ValidationJob)
+		if result := DB.Where("job_id = ?", c.Query("base")).First(base); result.Error != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "Base validation job not found"})
 		}
-
-		wg.Wait()
-		return
-	}
-
-	// If not an index, try to parse as a single sitemap (URL set)
-	var urlset models.XMLURLSet
-	if err := xml.Unmarshal(content, &urlset); err == nil && len(urlset.URLs) > 0 {
-		// Record success for the single sitemap
-		mutex.Lock()
-		writer.Write([]string{sitemapURL, "OK", "200", ""})
-		writer.Flush()
-		mutex.Unlock()
-
-		// Validate each URL in the sitemap with limited concurrency
-		var wg sync.WaitGroup
-		urlSemaphore := make(chan struct{}, 10) // Limit to 10 concurrent URL checks
-
-		for _, url := range urlset.URLs {
-			wg.Add(1)
-			urlSemaphore <- struct{}{} // Acquire semaphore
-
-			go func(loc string) {
-				defer wg.Done()
-				defer func() { <-urlSemaphore }() // Release semaphore
-
-				status, statusCode, err := checkURL(loc)
-
-				mutex.Lock()
-				if err != nil {
-					writer.Write([]string{loc, status, fmt.Sprintf("%d", statusCode), err.Error()})
-				} else {
-					writer.Write([]string{loc, status, fmt.Sprintf("%d", statusCode), ""})
-				}
-				writer.Flush()
-				mutex.Unlock()
-			}(url.Loc)
+	} else {
+		baseline, err := services.ValidationBaseline(DB, &head)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to find baseline", "details": err.Error()})
+		}
+		if baseline == nil {
+			return c.Status(404).JSON(fiber.Map{"error": "No base given and no baseline set for the head job's sitemap index or target"})
 		}
+		base = baseline
+	}
 
-		wg.Wait()
-		return
+	limit := c.QueryInt("limit", 1000)
+	if limit < 1 || limit > 10000 {
+		limit = 1000
 	}
 
-	// If neither sitemap index nor single sitemap, record an error
-	mutex.Lock()
-	writer.Write([]string{sitemapURL, "ERROR", "0", "Invalid XML format: not a valid sitemap index or URL set"})
+	comparison, err := services.CompareValidationJobs(DB, base, &head, limit)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to compare validation jobs", "details": err.Error()})
+	}
+	base.Options = base.Options.Redacted()
+	head.Options = head.Options.Redacted()
+	return c.JSON(fiber.Map{
+		"base":       base,
+		"head":       head,
+		"comparison": comparison,
+	})
+}
+
+// GetValidationResults returns the current validation results as a CSV download
+func GetValidationResults(c *fiber.Ctx) error {
+	jobID := c.Params("id")
+	var job models.ValidationJob
+	if result := DB.Where("job_id = ?", jobID).First(&job); result.Error != nil {
+		// Jobs from before results were stored in the database only have their CSV file
+		resultsFile := services.ValidationResultsFile(jobID)
+		if _, err := os.Stat(resultsFile); os.IsNotExist(err) {
+			return c.Status(404).JSON(fiber.Map{"error": "Validation job not found"})
+		}
+		return c.Download(resultsFile)
+	}
+
+	var results []models.ValidationResult
+	DB.Where("job_id = ?", job.ID).Order("id").Find(&results)
+
+	c.Set(fiber.HeaderContentType, "text/csv")
+	c.Attachment(jobID + ".csv")
+	writer := csv.NewWriter(c)
+	writer.Write([]string{"URL", "Status", "StatusCode", "Error", "Sitemap", "Depth", "FinalURL", "Redirects",
+		"Noindex", "Canonical", "CanonicalMismatch", "BodySize", "Soft404", "BlockedByRobots"})
+	for _, result := range results {
+		writer.Write([]string{result.URL, result.Status, strconv.Itoa(result.StatusCode), result.Error, result.Sitemap,
+			strconv.Itoa(result.Depth), result.FinalURL, strconv.Itoa(len(result.Redirects)),
+			strconv.FormatBool(result.Noindex), result.Canonical, strconv.FormatBool(result.CanonicalMismatch),
+			strconv.Itoa(result.BodySize), strconv.FormatBool(result.SoftNotFound), strconv.FormatBool(result.BlockedByRobots)})
+	}
 	writer.Flush()
-	mutex.Unlock()
+	return writer.Error()
 }
 
-func validateSitemap(sitemapURL string, writer *csv.Writer, mutex *sync.Mutex) {
-	// Fetch sitemap
-	content, err := fetchURL(sitemapURL)
+// ListValidationResults returns the results of a validation job as JSON, filtered by
+// ?status, ?type, ?sitemap, ?error, ?min_status_code and ?max_status_code, sorted by
+// ?sort (e.g. "-status_code") and paginated with ?page and ?per_page
+func ListValidationResults(c *fiber.Ctx) error {
+	var job models.ValidationJob
+	if result := DB.Where("job_id = ?", c.Params("id")).First(&job); result.Error != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Validation job not found"})
+	}
+
+	filter := services.ValidationResultFilter{}
+	if err := c.QueryParser(&filter); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid query parameters"})
+	}
+	if filter.Page < 1 {
+		filter.Page = 1
+	}
+	if filter.PerPage < 1 || filter.PerPage > 1000 {
+		filter.PerPage = 50
+	}
+
+	results, total, err := services.QueryValidationResults(DB, job.ID, filter)
 	if err != nil {
-		mutex.Lock()
-		writer.Write([]string{sitemapURL, "ERROR", "0", err.Error()})
-		writer.Flush()
-		mutex.Unlock()
-		return
-	}
-	
-	// Parse sitemap
-	var urlset models.XMLURLSet
-	if err := xml.Unmarshal(content, &urlset); err != nil {
-		mutex.Lock()
-		writer.Write([]string{sitemapURL, "ERROR", "0", "Invalid XML: " + err.Error()})
-		writer.Flush()
-		mutex.Unlock()
-		return
-	}
-	
-	// Record success for sitemap
-	mutex.Lock()
-	writer.Write([]string{sitemapURL, "OK", "200", ""})
-	writer.Flush()
-	mutex.Unlock()
-	
-	// Validate URLs with limited concurrency
-	var wg sync.WaitGroup
-	urlSemaphore := make(chan struct{}, 10) // Limit to 10 concurrent URL checks
-	
-	for _, url := range urlset.URLs {
-		wg.Add(1)
-		urlSemaphore <- struct{}{} // Acquire semaphore
-		
-		go func(loc string) {
-			defer wg.Done()
-			defer func() { <-urlSemaphore }() // Release semaphore
-			
-			status, statusCode, err := checkURL(loc)
-			
-			mutex.Lock()
-			if err != nil {
-				writer.Write([]string{loc, status, fmt.Sprintf("%d", statusCode), err.Error()})
-			} else {
-				writer.Write([]string{loc, status, fmt.Sprintf("%d", statusCode), ""})
-			}
-			writer.Flush()
-			mutex.Unlock()
-		}(url.Loc)
-	}
-	
-	wg.Wait()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to query results", "details": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"job_id":   job.JobID,
+		"state":    job.State,
+		"total":    total,
+		"page":     filter.Page,
+		"per_page": filter.PerPage,
+		"results":  results,
+	})
 }
 
-func checkURL(url string) (string, int, error) {
-	client := &http.Client{
-		Timeout: 5 * time.Second,
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			if len(via) >= 10 {
-				return fmt.Errorf("too many redirects")
-			}
-			return nil
-		},
-	}
-	
-	resp, err := client.Head(url)
+// GetValidationResultsByStatusCode counts the results of a validation job per status code
+func GetValidationResultsByStatusCode(c *fiber.Ctx) error {
+	var job models.ValidationJob
+	if result := DB.Where("job_id = ?", c.Params("id")).First(&job); result.Error != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Validation job not found"})
+	}
+
+	counts, err := services.ValidationResultsByStatusCode(DB, job.ID)
 	if err != nil {
-		return "ERROR", 0, err
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to query results", "details": err.Error()})
+	}
+	return c.JSON(counts)
+}
+
+// GetValidationResultsBySitemap counts the results of a validation job per sitemap they were listed in
+func GetValidationResultsBySitemap(c *fiber.Ctx) error {
+	var job models.ValidationJob
+	if result := DB.Where("job_id = ?", c.Params("id")).First(&job); result.Error != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Validation job not found"})
 	}
-	defer resp.Body.Close()
-	
-	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-		return "OK", resp.StatusCode, nil
+
+	counts, err := services.ValidationResultsBySitemap(DB, job.ID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to query results", "details": err.Error()})
 	}
-	
-	return "ERROR", resp.StatusCode, fmt.Errorf("status code %d", resp.StatusCode)
+	return c.JSON(counts)
 }
 
-func fetchURL(url string) ([]byte, error) {
-	client := &http.Client{
-		Timeout: 30 * time.Second,
+// ListValidationIssues returns the protocol issues found by a validation job, filtered by
+// ?severity, ?code and ?sitemap_url and paginated with ?page and ?per_page
+func ListValidationIssues(c *fiber.Ctx) error {
+	var job models.ValidationJob
+	if result := DB.Where("job_id = ?", c.Params("id")).First(&job); result.Error != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Validation job not found"})
+	}
+
+	filter := services.ValidationIssueFilter{}
+	if err := c.QueryParser(&filter); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid query parameters"})
+	}
+	if filter.Page < 1 {
+		filter.Page = 1
 	}
-	
-	resp, err := client.Get(url)
+	if filter.PerPage < 1 || filter.PerPage > 1000 {
+		filter.PerPage = 50
+	}
+
+	issues, total, err := services.QueryValidationIssues(DB, job.ID, filter)
 	if err != nil {
-		return nil, err
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to query issues", "details": err.Error()})
 	}
-	defer resp.Body.Close()
-	
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("status code %d", resp.StatusCode)
+
+	return c.JSON(fiber.Map{
+		"job_id":   job.JobID,
+		"state":    job.State,
+		"total":    total,
+		"page":     filter.Page,
+		"per_page": filter.PerPage,
+		"issues":   issues,
+	})
+}
+
+// GetValidationIssuesByCode counts the protocol issues found by a validation job per code
+func GetValidationIssuesByCode(c *fiber.Ctx) error {
+	var job models.ValidationJob
+	if result := DB.Where("job_id = ?", c.Params("id")).First(&job); result.Error != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Validation job not found"})
+	}
+
+	counts, err := services.ValidationIssuesByCode(DB, job.ID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to query issues", "details": err.Error()})
 	}
-	
-	return ioutil.ReadAll(resp.Body)
+	return c.JSON(counts)
 }
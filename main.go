@@ -5,6 +5,9 @@ import (
 	"sitemap-builder/handlers"
 	"sitemap-builder/models"
 	"sitemap-builder/middleware"
+	"sitemap-builder/secrets"
+	"sitemap-builder/services"
+	"sitemap-builder/utils"
 	"github.com/joho/godotenv"
 
 	"github.com/gofiber/fiber/v2"
@@ -22,12 +25,16 @@ var DB *gorm.DB
 func initDatabase() {
 	godotenv.Load()
 
+	if err := secrets.LoadKeys(); err != nil {
+		log.Fatal("Invalid secrets master key: ", err)
+	}
+
 	dbPath := "data/sitemap_builder.db"
 	dir := filepath.Dir(dbPath)
 
 	// Ensure the directory exists
 	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
-		log.Printf("Error creating directory:", err)
+		log.Printf("Error creating directory: %v", err)
 		return
 	}
 
@@ -35,7 +42,21 @@ func initDatabase() {
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
-	DB.AutoMigrate(&models.User{},&models.StorageConfig{}, &models.SitemapIndex{}, &models.Sitemap{}, &models.SitemapConfig{}, &models.Datasource{})
+	DB.AutoMigrate(&models.User{},&models.StorageConfig{}, &models.SitemapIndex{}, &models.Sitemap{}, &models.SitemapConfig{}, &models.Datasource{}, &models.CrawlConfig{}, &models.CrawlPage{}, &models.StaticURL{}, &models.GenerationRun{}, &models.GenerationRunTarget{}, &models.ValidationJob{}, &models.ValidationResult{}, &models.ValidationIssue{})
+
+	// Encrypt plaintext secrets and move old ones to the active master key
+	if rotated, err := services.RotateSecretKeys(DB); err != nil {
+		log.Printf("Error rotating secret keys: %v", err)
+	} else if rotated > 0 {
+		log.Printf("Re-encrypted %d secrets", rotated)
+	}
+
+	// Validation jobs run in the background and do not survive a restart
+	if failed, err := services.FailInterruptedValidations(DB); err != nil {
+		log.Printf("Error failing interrupted validations: %v", err)
+	} else if failed > 0 {
+		log.Printf("Marked %d interrupted validation jobs as failed", failed)
+	}
 
 	if os.Getenv("INIT_DB") == "true" {
 		seedDatabase(DB)
@@ -52,6 +73,9 @@ func initDatabase() {
 		}
 	}
 	handlers.SetDB(DB)
+
+	// Pool limits come from the environment, which is only loaded now
+	utils.Pools = utils.NewPoolManager()
 }
 
 func setupRoutes(app *fiber.App) {
@@ -61,8 +85,11 @@ func setupRoutes(app *fiber.App) {
 	if secretKey == "" {
 		secretKey = "default_secret_key" // Fallback default key
 	}
-	// Middleware for protected routes
-	app.Use(middleware.NewAuthMiddleware(secretKey))
+	// Middleware for protected routes; published sitemaps are public
+	app.Use(middleware.NewAuthMiddleware(secretKey, "/sitemaps/*"))
+
+	// Published sitemaps, read from their storage backend
+	app.Get("/sitemaps/*", handlers.ServeSitemap)
 
 	// API group
 	api := app.Group("/api")
@@ -77,6 +104,8 @@ func setupRoutes(app *fiber.App) {
 	sitemapIndex.Post("/", handlers.CreateSitemapIndex)
 	sitemapIndex.Put("/:id", handlers.UpdateSitemapIndex)
 	sitemapIndex.Delete("/:id", handlers.DeleteSitemapIndex)
+	sitemapIndex.Get("/:id/runs", handlers.GetGenerationRuns)
+	sitemapIndex.Post("/:id/validate", handlers.ValidateSitemapIndex)
 
 	// Sitemap routes
 	sitemap := api.Group("/sitemap")
@@ -93,6 +122,8 @@ func setupRoutes(app *fiber.App) {
 	config.Get("/", handlers.GetConfigs)
 	config.Get("/:id", handlers.GetConfig)
 	config.Post("/", handlers.CreateConfig)
+	config.Post("/preview", handlers.PreviewUnsavedConfig)
+	config.Post("/:id/preview", handlers.PreviewConfig)
 	config.Put("/:id", handlers.UpdateConfig)
 	config.Delete("/:id", handlers.DeleteConfig)
 
@@ -100,15 +131,38 @@ func setupRoutes(app *fiber.App) {
 	datasource := api.Group("/datasource")
 	datasource.Use(middleware.AdminOnly)
 	datasource.Get("/", handlers.GetDatasources)
+	datasource.Get("/pools", handlers.GetDatasourcePoolStats)
+	datasource.Post("/rotate-keys", handlers.RotateSecretKeys)
 	datasource.Get("/:id", handlers.GetDatasource)
 	datasource.Post("/", handlers.CreateDatasource)
 	datasource.Put("/:id", handlers.UpdateDatasource)
 	datasource.Delete("/:id", handlers.DeleteDatasource)
+	datasource.Get("/:id/health", handlers.GetDatasourceHealth)
+	datasource.Get("/:id/tables", handlers.GetDatasourceTables)
+	datasource.Get("/:id/tables/:name/columns", handlers.GetDatasourceColumns)
+	datasource.Post("/:id/reveal", handlers.RevealDatasource)
+	datasource.Post("/:id/test", handlers.TestDatasource)
+	datasource.Post("/:id/crawl", handlers.StartCrawl)
+	datasource.Get("/:id/crawl", handlers.GetCrawlStatus)
+	datasource.Get("/:id/urls", handlers.GetStaticURLs)
+	datasource.Post("/:id/urls", handlers.CreateStaticURL)
+	datasource.Post("/:id/urls/import", handlers.ImportStaticURLs)
+	datasource.Put("/:id/urls/:urlId", handlers.UpdateStaticURL)
+	datasource.Delete("/:id/urls/:urlId", handlers.DeleteStaticURL)
 
 	validation := api.Group("/validation")
 	validation.Use(middleware.AdminOnly)
 	validation.Post("/start", handlers.StartValidation)
+	validation.Get("/jobs", handlers.GetValidationJobs)
+	validation.Get("/jobs/:id", handlers.GetValidationJob)
+	validation.Post("/jobs/:id/baseline", handlers.SetValidationBaseline)
+	validation.Get("/compare", handlers.CompareValidationJobs)
 	validation.Get("/results/:id", handlers.GetValidationResults)
+	validation.Get("/:id/results", handlers.ListValidationResults)
+	validation.Get("/:id/results/by-status-code", handlers.GetValidationResultsByStatusCode)
+	validation.Get("/:id/results/by-sitemap", handlers.GetValidationResultsBySitemap)
+	validation.Get("/:id/issues", handlers.ListValidationIssues)
+	validation.Get("/:id/issues/by-code", handlers.GetValidationIssuesByCode)
 
 
 	// Protected route to trigger sitemap regeneration
@@ -138,6 +192,8 @@ type InitConfig struct {
 	SitemapIndexes []struct {
 		Name     string `json:"name"`
 		StorageConfigID uint   `json:"storage_config_id"`
+		StorageConfigIDs []uint `json:"storage_config_ids"`
+		FailurePolicy   string `json:"failure_policy"`
 		Sitemaps []struct {
 			Name   string `json:"name"`
 			Type   string `json:"type"`
@@ -197,6 +253,7 @@ func seedDatabase(db *gorm.DB) {
 			Name:             ds.Name,
 			Type:             ds.Type,
 			ConnectionString: ds.ConnectionString,
+			CrawlConfig:      ds.CrawlConfig,
 		}
 		db.Create(&newDS)
 		datasourceMap[ds.Name] = newDS.ID
@@ -208,11 +265,18 @@ func seedDatabase(db *gorm.DB) {
 	for _, index := range config.SitemapIndexes {
 		sitemapIndex := models.SitemapIndex{
             Name: index.Name,
+            FailurePolicy: index.FailurePolicy,
         }
 		db.Create(&sitemapIndex)
 
-		for i, sc := range config.StorageConfigs {
-			if uint(i) == index.StorageConfigID {
+		// storage_config_ids lists several targets, storage_config_id a single one
+		storageConfigIDs := index.StorageConfigIDs
+		if len(storageConfigIDs) == 0 {
+			storageConfigIDs = []uint{index.StorageConfigID}
+		}
+		for _, id := range storageConfigIDs {
+			if id < uint(len(config.StorageConfigs)) {
+				sc := config.StorageConfigs[id]
 				sc.SitemapIndexID = sitemapIndex.ID
 				db.Create(&sc)
 			}
@@ -1,22 +1,41 @@
 package middleware
 
 import (
+	"strings"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/contrib/jwt"
 	"github.com/golang-jwt/jwt/v5"
 
 )
 
-func NewAuthMiddleware(secret string) fiber.Handler {
+// NewAuthMiddleware requires a JWT on every route except the login route and
+// the given public paths. A path ending in "*" allows everything below it.
+func NewAuthMiddleware(secret string, publicPaths ...string) fiber.Handler {
+	publicPaths = append([]string{"/api/auth/login"}, publicPaths...)
+
 	return jwtware.New(jwtware.Config{
 		SigningKey: jwtware.SigningKey{Key: []byte(secret)},
 		ContextKey: "user",
 		Filter: func(c *fiber.Ctx) bool {
-			return c.Path() == "/api/auth/login"
+			return isPublicPath(c.Path(), publicPaths)
 		},
 	})
 }
 
+func isPublicPath(path string, publicPaths []string) bool {
+	for _, public := range publicPaths {
+		if prefix, ok := strings.CutSuffix(public, "*"); ok {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		} else if path == public {
+			return true
+		}
+	}
+	return false
+}
+
 func AdminOnly(c *fiber.Ctx) error {
 	claims := c.Locals("user").(*jwt.Token).Claims.(jwt.MapClaims)
 	if claims["role"] != "admin" {
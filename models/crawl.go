@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// CrawlConfig holds the crawl settings of a "crawl" datasource.
+// The seed URLs are stored in the datasource's ConnectionString.
+type CrawlConfig struct {
+	gorm.Model
+	DatasourceID      uint    `json:"datasource_id"`
+	MaxDepth          int     `json:"max_depth" gorm:"default:3"`
+	MaxPages          int     `json:"max_pages" gorm:"default:1000"`
+	RequestsPerSecond float64 `json:"requests_per_second" gorm:"default:1"`
+	UserAgent         string  `json:"user_agent" gorm:"default:'SitemapBuilderBot/1.0'"`
+	IgnoreRobots      bool    `json:"ignore_robots"`
+}
+
+// CrawlPage records the state of a URL discovered by a crawl, so crawls can resume.
+// Each crawl of a datasource is a new run; the pages of the previous run are kept
+// until the new run finishes.
+type CrawlPage struct {
+	gorm.Model
+	DatasourceID uint      `json:"datasource_id" gorm:"index"`
+	Run          int       `json:"run" gorm:"index;default:0"`
+	URL          string    `json:"url" gorm:"index"`
+	Depth        int       `json:"depth"`
+	State        string    `json:"state"` // "pending", "done", "error" or "skipped"
+	StatusCode   int       `json:"status_code"`
+	Title        string    `json:"title"`
+	LastModified string    `json:"last_modified"`
+	Canonical    string    `json:"canonical"`
+	Error        string    `json:"error"`
+	CrawledAt    time.Time `json:"crawled_at"`
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"sitemap-builder/secrets"
+)
+
+// EncryptedString is a string column that is encrypted at rest with the secrets master key
+type EncryptedString string
+
+// Value encrypts the string before it is written to the database
+func (s EncryptedString) Value() (driver.Value, error) {
+	return secrets.Encrypt(string(s))
+}
+
+// Scan decrypts the string when it is read from the database
+func (s *EncryptedString) Scan(value interface{}) error {
+	var stored string
+	switch v := value.(type) {
+	case nil:
+		stored = ""
+	case string:
+		stored = v
+	case []byte:
+		stored = string(v)
+	default:
+		return fmt.Errorf("unsupported type %T for encrypted string", value)
+	}
+
+	plaintext, err := secrets.Decrypt(stored)
+	if err != nil {
+		return err
+	}
+	*s = EncryptedString(plaintext)
+	return nil
+}
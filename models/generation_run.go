@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// GenerationRun records one generation of a sitemap index
+type GenerationRun struct {
+	gorm.Model
+	SitemapIndexID uint                  `json:"sitemap_index_id" gorm:"index"`
+	Status         string                `json:"status"` // "running", "success", "degraded" or "failed"
+	StartedAt      time.Time             `json:"started_at"`
+	FinishedAt     time.Time             `json:"finished_at"`
+	Error          string                `json:"error"`
+	Targets        []GenerationRunTarget `json:"targets" gorm:"foreignKey:GenerationRunID"`
+}
+
+// GenerationRunTarget records the outcome of publishing a run to one storage target
+type GenerationRunTarget struct {
+	gorm.Model
+	GenerationRunID uint   `json:"generation_run_id" gorm:"index"`
+	StorageConfigID uint   `json:"storage_config_id"`
+	Mode            string `json:"mode"`
+	Status          string `json:"status"` // "success" or "failed"
+	Files           int    `json:"files"`
+	Error           string `json:"error"`
+	Verify          string `json:"verify"`
+	VerifiedFiles   int    `json:"verified_files"`
+	VerifyFailures  int    `json:"verify_failures"`
+}
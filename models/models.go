@@ -2,6 +2,7 @@ package models
 
 import (
 	"encoding/xml"
+	"sitemap-builder/secrets"
 	"time"
 
 	"gorm.io/gorm"
@@ -13,7 +14,10 @@ type SitemapIndex struct {
 	Name           string    `json:"name"`
 	LastGeneration time.Time `json:"last_generation"`
 	Sitemaps       []Sitemap `json:"sitemaps" gorm:"foreignKey:SitemapIndexID"`
-	StorageConfig  StorageConfig `json:"storage_config" gorm:"foreignKey:SitemapIndexID"`
+	// Every storage config is a target the index is published to
+	StorageConfigs []StorageConfig `json:"storage_configs" gorm:"foreignKey:SitemapIndexID"`
+	// "fail" fails a run when any target fails, "degrade" only when all targets fail
+	FailurePolicy  string `json:"failure_policy" gorm:"default:'fail'"`
 }
 
 // Sitemap model
@@ -24,6 +28,8 @@ type Sitemap struct {
 	Config         SitemapConfig `json:"config" gorm:"foreignKey:SitemapID"`
 	LastGeneration time.Time     `json:"last_generation"`
 	FilePath       string        `json:"file_path"`
+	// Files are the storage keys of the files the last generation published
+	Files          []string      `json:"files" gorm:"serializer:json"`
 	Type		   string  		 `json:"type"`
 }
 
@@ -46,18 +52,66 @@ type SitemapConfig struct {
 type Datasource struct {
 	gorm.Model
 	Name             string `json:"name"`
-	Type             string `json:"type"` // e.g., "sqlite", "mysql", "postgres"
-	ConnectionString string `json:"connection_string"`
+	Type             string `json:"type"` // e.g., "sqlite", "mysql", "postgres", "crawl"
+	ConnectionString EncryptedString `json:"connection_string"` // seed URLs for "crawl" datasources
+	MaxOpenConns     int    `json:"max_open_conns"` // 0 uses DATASOURCE_MAX_OPEN_CONNS
+	MaxIdleConns     int    `json:"max_idle_conns"` // 0 uses DATASOURCE_MAX_IDLE_CONNS
+	CrawlConfig      *CrawlConfig `json:"crawl_config,omitempty" gorm:"foreignKey:DatasourceID"`
+}
+
+// Redacted returns a copy of the datasource with the credentials in its connection string hidden
+func (d Datasource) Redacted() Datasource {
+	d.ConnectionString = EncryptedString(secrets.RedactConnectionString(string(d.ConnectionString)))
+	return d
 }
 
 type StorageConfig struct {
     gorm.Model
     SitemapIndexID uint   `json:"sitemap_index_id"`
-    Mode           string `json:"mode" gorm:"default:'local'"` // "local" or "s3"
+    Mode           string `json:"mode" gorm:"default:'local'"` // "local", "s3", "sftp", "webdav" or "memory"
     Bucket         string `json:"bucket"`
     Region         string `json:"region"`
     Endpoint       string `json:"endpoint"`
     Path           string `json:"path" gorm:"default:'sitemaps/'"`
+    // PublicURL is the URL the files below Path are publicly served from, e.g.
+    // "https://cdn.example.com/sitemaps/"; the index lists its sitemaps under it
+    PublicURL      string `json:"public_url"`
+    // Credentials may be secret references such as "env:S3_KEY" or "file:/run/secrets/s3_secret"
+    AccessKeyID     string          `json:"access_key_id"`
+    SecretAccessKey EncryptedString `json:"secret_access_key"`
+    // S3 options; RoleARN is assumed with the credentials above, and ACL is only
+    // sent when set since buckets with object ownership enforced reject ACLs
+    RoleARN              string `json:"role_arn"`
+    ACL                  string `json:"acl"`
+    CacheControl         string `json:"cache_control"`
+    StorageClass         string `json:"storage_class"`
+    ServerSideEncryption string `json:"server_side_encryption"` // "AES256", "aws:kms" or "aws:kms:dsse"
+    SSEKMSKeyID          string `json:"sse_kms_key_id"`
+    UsePathStyle         bool   `json:"use_path_style"`
+    // Files larger than one part are uploaded in parts (default 8 MiB, at least 5),
+    // with up to MultipartConcurrency parts in flight (default 4)
+    MultipartPartSizeMB  int `json:"multipart_part_size_mb"`
+    MultipartConcurrency int `json:"multipart_concurrency"`
+    // Verify checks every published file: "checksum" compares the object's size and
+    // checksum where the backend reports one, "readback" reads it back and re-parses
+    // the XML; empty disables verification
+    Verify string `json:"verify"`
+    // SFTP and WebDAV login; the SFTP server's host key ("ssh-ed25519 AAAA...") is required
+    Username   string          `json:"username"`
+    Password   EncryptedString `json:"password"`
+    PrivateKey EncryptedString `json:"private_key"`
+    HostKey    string          `json:"host_key"`
+}
+
+// Redacted returns a copy of the storage config with its secrets hidden,
+// unless they are secret references
+func (s StorageConfig) Redacted() StorageConfig {
+	for _, secret := range []*EncryptedString{&s.SecretAccessKey, &s.Password, &s.PrivateKey} {
+		if *secret != "" && !secrets.IsReference(string(*secret)) {
+			*secret = secrets.Redacted
+		}
+	}
+	return s
 }
 
 // XML structures for sitemap generation
@@ -0,0 +1,13 @@
+package models
+
+import "gorm.io/gorm"
+
+// StaticURL is a URL managed directly in the app for a "static" datasource
+type StaticURL struct {
+	gorm.Model
+	DatasourceID uint    `json:"datasource_id" gorm:"index"`
+	Loc          string  `json:"loc"`
+	LastMod      string  `json:"lastmod"`
+	Priority     float64 `json:"priority"`
+	ChangeFreq   string  `json:"changefreq"`
+}
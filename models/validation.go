@@ -0,0 +1,161 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"sitemap-builder/secrets"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ValidationJob records a validation of a sitemap index or sitemap and its progress
+type ValidationJob struct {
+	gorm.Model
+	JobID      string            `json:"job_id" gorm:"uniqueIndex"`
+	Target     string            `json:"target"`
+	State      string            `json:"state"` // "pending", "running", "done" or "failed"
+	Total      int               `json:"total"`
+	Checked    int               `json:"checked"`
+	OKCount    int               `json:"ok_count"`
+	ErrorCount int               `json:"error_count"`
+	IssueCount int               `json:"issue_count"`
+	Progress   float64           `json:"progress"` // percentage of Total checked
+	StartedAt  time.Time         `json:"started_at"`
+	FinishedAt time.Time         `json:"finished_at"`
+	UserID     uint              `json:"user_id"`
+	Error      string            `json:"error"`
+	Options    ValidationOptions `json:"options" gorm:"embedded;embeddedPrefix:option_"`
+	Source     string            `json:"source"` // "http", or "storage" for generated files read from a storage backend
+	// The sitemap index and storage target of "storage" jobs
+	SitemapIndexID  uint `json:"sitemap_index_id" gorm:"index"`
+	StorageConfigID uint `json:"storage_config_id"`
+	// Baseline marks the job later jobs of the same sitemap index or target are compared with
+	Baseline bool `json:"baseline"`
+}
+
+// ValidationOptions controls the HTTP requests of a validation job
+type ValidationOptions struct {
+	Method            string            `json:"method"` // "HEAD" (falls back to GET on 405 and 501) or "GET"
+	TimeoutSeconds    int               `json:"timeout_seconds"`
+	Retries           int               `json:"retries"`             // retries of failed requests, 429 and 5xx responses
+	RetryBackoffMS    int               `json:"retry_backoff_ms"`    // delay before the first retry, doubled for each further one
+	RequestsPerSecond float64           `json:"requests_per_second"` // per host, 0 for no limit
+	Concurrency       int               `json:"concurrency"`         // requests in flight across the job
+	UserAgent         string            `json:"user_agent"`
+	RobotsUserAgent   string            `json:"robots_user_agent"` // whose robots.txt rules URLs are checked against
+	Headers           ValidationHeaders `json:"headers"`
+	SkipURLChecks     bool              `json:"skip_url_checks"` // only check the sitemap files, not the URLs they list
+	// Deep validation GETs every URL and checks that it is an indexable, canonical page
+	Deep                 bool     `json:"deep"`
+	SoftNotFoundMinBytes int      `json:"soft_404_min_bytes"`                      // smaller pages are reported as soft 404s
+	SoftNotFoundMarkers  []string `json:"soft_404_markers" gorm:"serializer:json"` // texts of the site's error page
+}
+
+// Redacted returns a copy of the options with the values of credential headers
+// hidden. Secret references are not secrets themselves and are kept.
+func (o ValidationOptions) Redacted() ValidationOptions {
+	if len(o.Headers) == 0 {
+		return o
+	}
+	headers := make(ValidationHeaders, len(o.Headers))
+	for name, value := range o.Headers {
+		if secrets.IsReference(value) {
+			headers[name] = value
+			continue
+		}
+		switch http.CanonicalHeaderKey(name) {
+		case "Authorization", "Proxy-Authorization", "Cookie":
+			value = secrets.Redacted
+		default:
+			lower := strings.ToLower(name)
+			if strings.Contains(lower, "token") || strings.Contains(lower, "key") || strings.Contains(lower, "secret") {
+				value = secrets.Redacted
+			}
+		}
+		headers[name] = value
+	}
+	o.Headers = headers
+	return o
+}
+
+// ValidationHeaders are the extra request headers of a validation job. They often
+// carry credentials, so they are stored as JSON encrypted with the secrets master
+// key. Values may also be secret references, resolved when the job runs.
+type ValidationHeaders map[string]string
+
+// Value encrypts the headers before they are written to the database
+func (h ValidationHeaders) Value() (driver.Value, error) {
+	if len(h) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(map[string]string(h))
+	if err != nil {
+		return nil, err
+	}
+	return secrets.Encrypt(string(data))
+}
+
+// Scan decrypts the headers when they are read from the database. Headers stored
+// before they were encrypted are read as they are.
+func (h *ValidationHeaders) Scan(value interface{}) error {
+	var stored EncryptedString
+	if err := stored.Scan(value); err != nil {
+		return err
+	}
+	*h = nil
+	if stored == "" || stored == "null" {
+		return nil
+	}
+	return json.Unmarshal([]byte(stored), h)
+}
+
+// GormDataType stores the headers in a text column
+func (ValidationHeaders) GormDataType() string {
+	return "string"
+}
+
+// ValidationResult is the outcome of checking one sitemap index, sitemap or URL of a validation job
+type ValidationResult struct {
+	ID         uint   `json:"id" gorm:"primarykey"`
+	JobID      uint   `json:"-" gorm:"index"`
+	URL        string `json:"url"`
+	Type       string `json:"type"`    // "index", "sitemap" or "url"
+	Sitemap    string `json:"sitemap"` // the file the entry was listed in, empty for the job's target
+	Depth      int    `json:"depth"`   // the number of indexes above the entry, 0 for the job's target
+	Status     string `json:"status"`  // "OK", "ERROR" or "SKIPPED" when URL checks are skipped
+	StatusCode int    `json:"status_code"`
+	Error      string `json:"error"`
+	// FinalURL is the URL that answered after redirects, Redirects the hops that led to it
+	FinalURL  string        `json:"final_url"`
+	Redirects []RedirectHop `json:"redirects" gorm:"serializer:json"`
+	// Set by deep validation only
+	Noindex           bool   `json:"noindex"` // from the robots meta tag or the X-Robots-Tag header
+	XRobotsTag        string `json:"x_robots_tag"`
+	MetaRobots        string `json:"meta_robots"`
+	Canonical         string `json:"canonical"`
+	CanonicalMismatch bool   `json:"canonical_mismatch"`
+	BodySize          int    `json:"body_size"`
+	SoftNotFound      bool   `json:"soft_404"`
+	BlockedByRobots   bool   `json:"blocked_by_robots"`
+}
+
+// RedirectHop is a redirect response received while checking a URL
+type RedirectHop struct {
+	URL        string `json:"url"`
+	StatusCode int    `json:"status_code"`
+	Location   string `json:"location"`
+}
+
+// ValidationIssue is a protocol violation found in a sitemap index or sitemap of a validation job
+type ValidationIssue struct {
+	ID         uint   `json:"id" gorm:"primarykey"`
+	JobID      uint   `json:"-" gorm:"index"`
+	SitemapURL string `json:"sitemap_url"` // the file the issue was found in
+	URL        string `json:"url"`         // the entry the issue concerns, empty for the file itself
+	Code       string `json:"code"`
+	Severity   string `json:"severity"` // "error", "warning" or "info"
+	Message    string `json:"message"`
+}
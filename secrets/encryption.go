@@ -0,0 +1,242 @@
+// Package secrets encrypts sensitive configuration at rest.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Encrypted values are stored as enc:v1:<key id>:<wrapped data key>:<ciphertext>.
+// Each value has its own random data key, which is encrypted ("wrapped") with
+// the master key, so rotating the master key only re-wraps the data keys.
+const encryptedPrefix = "enc:v1:"
+
+type masterKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+var (
+	keysMu    sync.RWMutex
+	activeKey *masterKey
+	allKeys   = map[string]*masterKey{}
+)
+
+// LoadKeys reads the master key from SECRETS_MASTER_KEY and any keys it replaced
+// from SECRETS_PREVIOUS_MASTER_KEYS (comma separated). Keys are base64 encoded
+// 32 byte AES keys. Without a master key, values are stored in plaintext.
+func LoadKeys() error {
+	keysMu.Lock()
+	defer keysMu.Unlock()
+
+	activeKey = nil
+	allKeys = map[string]*masterKey{}
+
+	if encoded := strings.TrimSpace(os.Getenv("SECRETS_MASTER_KEY")); encoded != "" {
+		key, err := newMasterKey(encoded)
+		if err != nil {
+			return fmt.Errorf("SECRETS_MASTER_KEY: %v", err)
+		}
+		activeKey = key
+		allKeys[key.id] = key
+	}
+
+	for _, encoded := range strings.Split(os.Getenv("SECRETS_PREVIOUS_MASTER_KEYS"), ",") {
+		if encoded = strings.TrimSpace(encoded); encoded == "" {
+			continue
+		}
+		key, err := newMasterKey(encoded)
+		if err != nil {
+			return fmt.Errorf("SECRETS_PREVIOUS_MASTER_KEYS: %v", err)
+		}
+		allKeys[key.id] = key
+	}
+	return nil
+}
+
+// Enabled reports whether a master key is configured
+func Enabled() bool {
+	keysMu.RLock()
+	defer keysMu.RUnlock()
+	return activeKey != nil
+}
+
+func newMasterKey(encoded string) (*masterKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64: %v", err)
+	}
+	if len(raw) != 32 {
+		return nil, fmt.Errorf("key must be 32 bytes, got %d", len(raw))
+	}
+	aead, err := newAEAD(raw)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(raw)
+	return &masterKey{id: hex.EncodeToString(sum[:4]), aead: aead}, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext, prefixing the random nonce
+func seal(aead cipher.AEAD, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// open decrypts the output of seal
+func open(aead cipher.AEAD, sealed []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
+
+// IsEncrypted reports whether a stored value is encrypted
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix)
+}
+
+// Encrypt encrypts a value with a new data key wrapped by the active master key.
+// Empty values, and all values when no master key is configured, are returned unchanged.
+func Encrypt(plaintext string) (string, error) {
+	keysMu.RLock()
+	key := activeKey
+	keysMu.RUnlock()
+
+	if plaintext == "" || key == nil || IsEncrypted(plaintext) {
+		return plaintext, nil
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	ciphertext, err := seal(dataAEAD, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+	wrappedKey, err := seal(key.aead, dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	return encryptedPrefix + key.id + ":" +
+		base64.RawURLEncoding.EncodeToString(wrappedKey) + ":" +
+		base64.RawURLEncoding.EncodeToString(ciphertext), nil
+}
+
+// Decrypt decrypts a value produced by Encrypt. Plaintext values are returned unchanged.
+func Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+
+	keyID, dataKey, ciphertext, err := unwrap(value)
+	if err != nil {
+		return "", err
+	}
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(dataAEAD, ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("decrypting value under key %s: %v", keyID, err)
+	}
+	return string(plaintext), nil
+}
+
+// unwrap splits an encrypted value and decrypts its data key with the matching master key
+func unwrap(value string) (string, []byte, []byte, error) {
+	parts := strings.Split(strings.TrimPrefix(value, encryptedPrefix), ":")
+	if len(parts) != 3 {
+		return "", nil, nil, fmt.Errorf("malformed encrypted value")
+	}
+	keyID := parts[0]
+
+	keysMu.RLock()
+	key, ok := allKeys[keyID]
+	keysMu.RUnlock()
+	if !ok {
+		return "", nil, nil, fmt.Errorf("unknown master key %s", keyID)
+	}
+
+	wrappedKey, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("malformed data key: %v", err)
+	}
+	ciphertext, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("malformed ciphertext: %v", err)
+	}
+	dataKey, err := open(key.aead, wrappedKey)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("unwrapping data key under key %s: %v", keyID, err)
+	}
+	return keyID, dataKey, ciphertext, nil
+}
+
+// NeedsRotation reports whether a stored value is not yet encrypted under the active master key
+func NeedsRotation(value string) bool {
+	keysMu.RLock()
+	key := activeKey
+	keysMu.RUnlock()
+
+	if key == nil || value == "" {
+		return false
+	}
+	return !strings.HasPrefix(value, encryptedPrefix+key.id+":")
+}
+
+// Rotate re-wraps the data key of a stored value with the active master key,
+// or encrypts the value if it is still plaintext
+func Rotate(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return Encrypt(value)
+	}
+
+	keysMu.RLock()
+	key := activeKey
+	keysMu.RUnlock()
+	if key == nil {
+		return value, nil
+	}
+
+	_, dataKey, ciphertext, err := unwrap(value)
+	if err != nil {
+		return "", err
+	}
+	wrappedKey, err := seal(key.aead, dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	return encryptedPrefix + key.id + ":" +
+		base64.RawURLEncoding.EncodeToString(wrappedKey) + ":" +
+		base64.RawURLEncoding.EncodeToString(ciphertext), nil
+}
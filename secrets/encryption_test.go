@@ -0,0 +1,196 @@
+package secrets
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+var (
+	testKeyA = base64.StdEncoding.EncodeToString([]byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"))
+	testKeyB = base64.StdEncoding.EncodeToString([]byte("bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"))
+)
+
+// useKeys loads an active master key and the keys it replaced for the duration of a test
+func useKeys(t *testing.T, active string, previous ...string) {
+	t.Helper()
+	t.Setenv("SECRETS_MASTER_KEY", active)
+	t.Setenv("SECRETS_PREVIOUS_MASTER_KEYS", strings.Join(previous, ","))
+	if err := LoadKeys(); err != nil {
+		t.Fatalf("LoadKeys: %v", err)
+	}
+	t.Cleanup(func() {
+		keysMu.Lock()
+		activeKey, allKeys = nil, map[string]*masterKey{}
+		keysMu.Unlock()
+	})
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	useKeys(t, testKeyA)
+
+	tests := []struct {
+		name      string
+		plaintext string
+	}{
+		{"url", "postgres://app:secret@db:5432/app?sslmode=disable"},
+		{"key value", "host=db user=app password='s3 cr:et'"},
+		{"unicode", "pässwörd-🔑"},
+		{"colons", "a:b:c:d"},
+		{"long", strings.Repeat("x", 10000)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encrypted, err := Encrypt(tt.plaintext)
+			if err != nil {
+				t.Fatalf("Encrypt: %v", err)
+			}
+			if !IsEncrypted(encrypted) || strings.Contains(encrypted, tt.plaintext) {
+				t.Fatalf("Encrypt returned %q, want an encrypted value", encrypted)
+			}
+			if again, _ := Encrypt(tt.plaintext); again == encrypted {
+				t.Errorf("encrypting twice gave the same value, want a new data key each time")
+			}
+			if twice, _ := Encrypt(encrypted); twice != encrypted {
+				t.Errorf("encrypting an encrypted value changed it")
+			}
+
+			decrypted, err := Decrypt(encrypted)
+			if err != nil {
+				t.Fatalf("Decrypt: %v", err)
+			}
+			if decrypted != tt.plaintext {
+				t.Errorf("Decrypt = %q, want %q", decrypted, tt.plaintext)
+			}
+		})
+	}
+}
+
+func TestEncryptWithoutKey(t *testing.T) {
+	useKeys(t, "")
+
+	for _, plaintext := range []string{"", "postgres://app:secret@db/app"} {
+		encrypted, err := Encrypt(plaintext)
+		if err != nil || encrypted != plaintext {
+			t.Errorf("Encrypt(%q) = %q, %v, want the value unchanged", plaintext, encrypted, err)
+		}
+		if decrypted, err := Decrypt(plaintext); err != nil || decrypted != plaintext {
+			t.Errorf("Decrypt(%q) = %q, %v, want the value unchanged", plaintext, decrypted, err)
+		}
+	}
+}
+
+func TestDecryptErrors(t *testing.T) {
+	useKeys(t, testKeyA)
+	encrypted, err := Encrypt("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(strings.TrimPrefix(encrypted, encryptedPrefix), ":")
+
+	tests := []struct {
+		name  string
+		value string
+		keys  []string // the active key first
+		want  string
+	}{
+		{"wrong key", encrypted, []string{testKeyB}, "unknown master key"},
+		{"malformed", encryptedPrefix + "abc", []string{testKeyA}, "malformed encrypted value"},
+		{"bad data key encoding", encryptedPrefix + parts[0] + ":!!:" + parts[2], []string{testKeyA}, "malformed data key"},
+		{"tampered data key", encryptedPrefix + parts[0] + ":" + flipLast(parts[1]) + ":" + parts[2], []string{testKeyA}, "unwrapping data key"},
+		{"tampered ciphertext", encryptedPrefix + parts[0] + ":" + parts[1] + ":" + flipLast(parts[2]), []string{testKeyA}, "decrypting value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useKeys(t, tt.keys[0], tt.keys[1:]...)
+			_, err := Decrypt(tt.value)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Decrypt error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// flipLast changes the last character of a base64 value, keeping it valid base64
+func flipLast(s string) string {
+	last := s[len(s)-1]
+	replacement := byte('A')
+	if last == 'A' {
+		replacement = 'B'
+	}
+	return s[:len(s)-1] + string(replacement)
+}
+
+func TestRotate(t *testing.T) {
+	useKeys(t, testKeyA)
+	underA, err := Encrypt("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if NeedsRotation(underA) {
+		t.Errorf("value encrypted under the active key needs rotation")
+	}
+
+	// Key B replaces key A, which can still decrypt
+	useKeys(t, testKeyB, testKeyA)
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"encrypted under the previous key", underA},
+		{"plaintext", "secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !NeedsRotation(tt.value) {
+				t.Fatalf("NeedsRotation = false, want true")
+			}
+			rotated, err := Rotate(tt.value)
+			if err != nil {
+				t.Fatalf("Rotate: %v", err)
+			}
+			if NeedsRotation(rotated) {
+				t.Errorf("rotated value still needs rotation")
+			}
+			if decrypted, err := Decrypt(rotated); err != nil || decrypted != "secret" {
+				t.Errorf("Decrypt after rotation = %q, %v", decrypted, err)
+			}
+		})
+	}
+
+	rotated, _ := Rotate(underA)
+	// Once rotated, values no longer need the previous key
+	useKeys(t, testKeyB)
+	if decrypted, err := Decrypt(rotated); err != nil || decrypted != "secret" {
+		t.Errorf("Decrypt without the previous key = %q, %v", decrypted, err)
+	}
+	if _, err := Decrypt(underA); err == nil {
+		t.Errorf("value under the dropped key decrypted")
+	}
+}
+
+func TestLoadKeysRejectsInvalidKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		active   string
+		previous string
+	}{
+		{"not base64", "not base64!", ""},
+		{"too short", base64.StdEncoding.EncodeToString([]byte("short")), ""},
+		{"invalid previous key", testKeyA, "not base64!"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SECRETS_MASTER_KEY", tt.active)
+			t.Setenv("SECRETS_PREVIOUS_MASTER_KEYS", tt.previous)
+			t.Cleanup(func() {
+				keysMu.Lock()
+				activeKey, allKeys = nil, map[string]*masterKey{}
+				keysMu.Unlock()
+			})
+			if err := LoadKeys(); err == nil {
+				t.Errorf("LoadKeys accepted an invalid key")
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package secrets
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces credentials in redacted values
+const Redacted = "*****"
+
+var (
+	// key=value DSNs, e.g. "host=db user=app password=secret"
+	keyValuePassword = regexp.MustCompile(`(?i)\b(password|passwd|pwd|sslpassword)\s*=\s*('[^']*'|[^\s;&]+)`)
+	// MySQL style DSNs, e.g. "app:secret@tcp(db:3306)/app"
+	mysqlPassword = regexp.MustCompile(`^([^:/@\s]+):([^@]*)@`)
+	// placeholders standing in for secret references while redacting
+	refToken = regexp.MustCompile(`^secretref\d+x$`)
+)
+
+// RedactConnectionString hides the passwords in a connection string.
+// Secret references are not secrets themselves and are kept.
+func RedactConnectionString(connectionString string) string {
+	if connectionString == "" || IsReference(connectionString) {
+		return connectionString
+	}
+	if IsEncrypted(connectionString) {
+		return Redacted
+	}
+
+	// Swap references for plain tokens so they survive parsing
+	var refs []string
+	protected := templateRef.ReplaceAllStringFunc(connectionString, func(ref string) string {
+		refs = append(refs, ref)
+		return fmt.Sprintf("secretref%dx", len(refs)-1)
+	})
+
+	redacted := redactPasswords(protected)
+	for i, ref := range refs {
+		redacted = strings.Replace(redacted, fmt.Sprintf("secretref%dx", i), ref, 1)
+	}
+	return redacted
+}
+
+func redactPasswords(connectionString string) string {
+	redactKeyValues := func(s string) string {
+		return keyValuePassword.ReplaceAllStringFunc(s, func(match string) string {
+			parts := keyValuePassword.FindStringSubmatch(match)
+			if refToken.MatchString(parts[2]) {
+				return match
+			}
+			return parts[1] + "=" + Redacted
+		})
+	}
+
+	// URL style, e.g. "postgres://app:secret@db/app?sslmode=disable"
+	if strings.Contains(connectionString, "://") {
+		if u, err := url.Parse(connectionString); err == nil {
+			if password, hasPassword := u.User.Password(); hasPassword && !refToken.MatchString(password) {
+				u.User = url.UserPassword(u.User.Username(), Redacted)
+			}
+			// url.URL escapes the asterisks in the user info
+			return redactKeyValues(strings.Replace(u.String(), url.PathEscape(Redacted), Redacted, 1))
+		}
+	}
+
+	redacted := redactKeyValues(connectionString)
+	return mysqlPassword.ReplaceAllStringFunc(redacted, func(match string) string {
+		parts := mysqlPassword.FindStringSubmatch(match)
+		if refToken.MatchString(parts[2]) {
+			return match
+		}
+		return parts[1] + ":" + Redacted + "@"
+	})
+}
@@ -0,0 +1,34 @@
+package secrets
+
+import "testing"
+
+func TestRedactConnectionString(t *testing.T) {
+	tests := []struct {
+		name string
+		dsn  string
+		want string
+	}{
+		{"empty", "", ""},
+		{"url", "postgres://app:secret@db:5432/app?sslmode=disable", "postgres://app:*****@db:5432/app?sslmode=disable"},
+		{"url without password", "postgres://app@db/app", "postgres://app@db/app"},
+		{"url with password parameter", "sqlserver://app@db?database=app&password=secret", "sqlserver://app@db?database=app&password=*****"},
+		{"key value", "host=db user=app password=secret dbname=app", "host=db user=app password=***** dbname=app"},
+		{"key value quoted", "host=db password='s3 cret' dbname=app", "host=db password=***** dbname=app"},
+		{"key value semicolons", "Server=db;User Id=app;Pwd=secret;", "Server=db;User Id=app;Pwd=*****;"},
+		{"mysql", "app:secret@tcp(db:3306)/app?parseTime=true", "app:*****@tcp(db:3306)/app?parseTime=true"},
+		{"sqlite", "data/app.db", "data/app.db"},
+		{"env reference", "env:PG_URL", "env:PG_URL"},
+		{"file reference", "file:/run/secrets/pg", "file:/run/secrets/pg"},
+		{"embedded reference in url", "postgres://app:${env:PG_PASS}@db/app", "postgres://app:${env:PG_PASS}@db/app"},
+		{"embedded reference in key value", "host=db password=${file:/run/secrets/pg}", "host=db password=${file:/run/secrets/pg}"},
+		{"embedded reference in mysql", "app:${env:MYSQL_PASS}@tcp(db)/app", "app:${env:MYSQL_PASS}@tcp(db)/app"},
+		{"encrypted", encryptedPrefix + "0a1b2c3d:key:data", Redacted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RedactConnectionString(tt.dsn); got != tt.want {
+				t.Errorf("RedactConnectionString(%q) = %q, want %q", tt.dsn, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package secrets
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Configuration values may reference secrets instead of containing them:
+//
+//	env:PG_URL                            the value of an environment variable
+//	file:/run/secrets/pg                  the content of a file
+//	postgres://app:${env:PG_PASS}@db/app  references embedded in a value
+var templateRef = regexp.MustCompile(`\$\{(env|file):([^}]+)\}`)
+
+// IsReference reports whether the whole value is a secret reference
+func IsReference(value string) bool {
+	return strings.HasPrefix(value, "env:") || strings.HasPrefix(value, "file:")
+}
+
+// Resolve replaces secret references in a value with the secrets they point to
+func Resolve(value string) (string, error) {
+	if kind, name, ok := strings.Cut(value, ":"); ok && (kind == "env" || kind == "file") {
+		return lookup(kind, name)
+	}
+	return ResolveTemplates(value)
+}
+
+// ResolveTemplates only replaces ${env:NAME} and ${file:/path} references embedded in a value
+func ResolveTemplates(value string) (string, error) {
+	var resolveErr error
+	resolved := templateRef.ReplaceAllStringFunc(value, func(match string) string {
+		parts := templateRef.FindStringSubmatch(match)
+		secret, err := lookup(parts[1], parts[2])
+		if err != nil && resolveErr == nil {
+			resolveErr = err
+		}
+		return secret
+	})
+	if resolveErr != nil {
+		return "", resolveErr
+	}
+	return resolved, nil
+}
+
+func lookup(kind, name string) (string, error) {
+	switch kind {
+	case "env":
+		secret, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("secret reference env:%s: environment variable not set", name)
+		}
+		return secret, nil
+	case "file":
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return "", fmt.Errorf("secret reference file:%s: %v", name, err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	return "", fmt.Errorf("unknown secret reference %s:%s", kind, name)
+}
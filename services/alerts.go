@@ -0,0 +1,40 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// sendAlert logs an alert and posts it as JSON to ALERT_WEBHOOK_URL, if set
+func sendAlert(message string, details map[string]interface{}) {
+	log.Printf("ALERT: %s %v", message, details)
+
+	webhookURL := os.Getenv("ALERT_WEBHOOK_URL")
+	if webhookURL == "" {
+		return
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"text":    message,
+		"details": details,
+	})
+	if err != nil {
+		log.Printf("Error encoding alert: %v", err)
+		return
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(webhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Error sending alert: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("Error sending alert: webhook status code %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,425 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sitemap-builder/models"
+	"sitemap-builder/utils"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// maxCrawlPageSize limits how much of a page is read when extracting links
+const maxCrawlPageSize = 5 * 1024 * 1024
+
+var (
+	crawlMu      sync.Mutex
+	activeCrawls = map[uint]chan struct{}{}
+)
+
+// RunCrawl crawls a "crawl" datasource. An interrupted crawl is resumed from its
+// pending pages, otherwise a new run starts over from the seed URLs. The pages of
+// the previous run are only replaced once the new run finishes. If the datasource
+// is already being crawled, RunCrawl waits for that crawl instead.
+func RunCrawl(db *gorm.DB, datasource *models.Datasource) error {
+	crawlMu.Lock()
+	if done, running := activeCrawls[datasource.ID]; running {
+		crawlMu.Unlock()
+		<-done
+		return nil
+	}
+	done := make(chan struct{})
+	activeCrawls[datasource.ID] = done
+	crawlMu.Unlock()
+
+	defer func() {
+		crawlMu.Lock()
+		delete(activeCrawls, datasource.ID)
+		crawlMu.Unlock()
+		close(done)
+	}()
+
+	c, err := newCrawler(db, datasource)
+	if err != nil {
+		return err
+	}
+	return c.crawl()
+}
+
+// IsCrawlRunning reports whether a crawl of the datasource is in progress
+func IsCrawlRunning(datasourceID uint) bool {
+	crawlMu.Lock()
+	defer crawlMu.Unlock()
+	_, running := activeCrawls[datasourceID]
+	return running
+}
+
+// CrawlStatus returns the number of pages per state of the latest crawl run
+func CrawlStatus(db *gorm.DB, datasourceID uint) (map[string]int64, error) {
+	run, err := latestCrawlRun(db, datasourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	type stateCount struct {
+		State string
+		Count int64
+	}
+	var counts []stateCount
+	err = db.Model(&models.CrawlPage{}).
+		Select("state, count(*) as count").
+		Where("datasource_id = ? AND run = ?", datasourceID, run).
+		Group("state").
+		Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	status := map[string]int64{"pending": 0, "done": 0, "error": 0, "skipped": 0}
+	for _, c := range counts {
+		status[c.State] = c.Count
+	}
+	return status, nil
+}
+
+// latestCrawlRun returns the run of the datasource's most recent crawl, finished or not
+func latestCrawlRun(db *gorm.DB, datasourceID uint) (int, error) {
+	var run int
+	err := db.Model(&models.CrawlPage{}).
+		Select("COALESCE(MAX(run), 0)").
+		Where("datasource_id = ?", datasourceID).
+		Scan(&run).Error
+	return run, err
+}
+
+// finishedCrawlRun returns the run of the datasource's most recent crawl that has
+// no pending pages left, or 0 if no crawl has finished yet
+func finishedCrawlRun(db *gorm.DB, datasourceID uint) (int, error) {
+	var run int
+	err := db.Model(&models.CrawlPage{}).
+		Select("COALESCE(MAX(run), 0)").
+		Where("datasource_id = ?", datasourceID).
+		Where("run NOT IN (?)", db.Model(&models.CrawlPage{}).
+			Select("run").
+			Where("datasource_id = ? AND state = ?", datasourceID, "pending")).
+		Scan(&run).Error
+	return run, err
+}
+
+type crawler struct {
+	db         *gorm.DB
+	datasource *models.Datasource
+	config     models.CrawlConfig
+	seeds      []*url.URL
+	hosts      map[string]bool
+	robots     map[string]*utils.Robots
+	client     *http.Client
+	lastFetch  time.Time
+	run        int
+}
+
+func newCrawler(db *gorm.DB, datasource *models.Datasource) (*crawler, error) {
+	seeds, err := utils.ParseSeedURLs(string(datasource.ConnectionString))
+	if err != nil {
+		return nil, err
+	}
+
+	config := models.CrawlConfig{
+		MaxDepth:          3,
+		MaxPages:          1000,
+		RequestsPerSecond: 1,
+		UserAgent:         "SitemapBuilderBot/1.0",
+	}
+	if err := db.Where("datasource_id = ?", datasource.ID).Limit(1).Find(&config).Error; err != nil {
+		return nil, err
+	}
+
+	hosts := make(map[string]bool)
+	for _, seed := range seeds {
+		hosts[strings.ToLower(seed.Host)] = true
+	}
+
+	return &crawler{
+		db:         db,
+		datasource: datasource,
+		config:     config,
+		seeds:      seeds,
+		hosts:      hosts,
+		robots:     make(map[string]*utils.Robots),
+		client:     &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (c *crawler) crawl() error {
+	dsID := c.datasource.ID
+
+	latest, err := latestCrawlRun(c.db, dsID)
+	if err != nil {
+		return err
+	}
+	var pending int64
+	c.db.Model(&models.CrawlPage{}).Where("datasource_id = ? AND run = ? AND state = ?", dsID, latest, "pending").Count(&pending)
+	if pending == 0 {
+		c.run = latest + 1
+		log.Printf("Starting crawl of datasource %s (run %d)", c.datasource.Name, c.run)
+		for _, seed := range c.seeds {
+			if link, ok := resolveLink(seed, seed.String()); ok {
+				seed = link
+			}
+			if err := c.enqueue(seed, 0); err != nil {
+				return err
+			}
+		}
+	} else {
+		c.run = latest
+		log.Printf("Resuming crawl of datasource %s (%d pages pending)", c.datasource.Name, pending)
+	}
+
+	var fetched int64
+	c.db.Model(&models.CrawlPage{}).Where("datasource_id = ? AND run = ? AND state IN ?", dsID, c.run, []string{"done", "error"}).Count(&fetched)
+
+	for {
+		if c.config.MaxPages > 0 && fetched >= int64(c.config.MaxPages) {
+			log.Printf("Crawl of datasource %s reached the limit of %d pages", c.datasource.Name, c.config.MaxPages)
+			err := c.db.Model(&models.CrawlPage{}).
+				Where("datasource_id = ? AND run = ? AND state = ?", dsID, c.run, "pending").
+				Updates(map[string]interface{}{"state": "skipped", "error": "page limit reached"}).Error
+			if err != nil {
+				return err
+			}
+			return c.finish()
+		}
+
+		var pages []models.CrawlPage
+		err := c.db.Where("datasource_id = ? AND run = ? AND state = ?", dsID, c.run, "pending").Order("id").Limit(1).Find(&pages).Error
+		if err != nil {
+			return err
+		}
+		if len(pages) == 0 {
+			log.Printf("Finished crawl of datasource %s", c.datasource.Name)
+			return c.finish()
+		}
+
+		page := pages[0]
+		if c.crawlPage(&page) {
+			fetched++
+		}
+		if err := c.db.Save(&page).Error; err != nil {
+			return err
+		}
+	}
+}
+
+// finish removes the pages of the runs before the finished one
+func (c *crawler) finish() error {
+	return c.db.Unscoped().
+		Where("datasource_id = ? AND run < ?", c.datasource.ID, c.run).
+		Delete(&models.CrawlPage{}).Error
+}
+
+// crawlPage fetches a pending page, records the result on it and enqueues its links.
+// It reports whether a request was made.
+func (c *crawler) crawlPage(page *models.CrawlPage) bool {
+	u, err := url.Parse(page.URL)
+	if err != nil {
+		page.State = "error"
+		page.Error = err.Error()
+		return false
+	}
+
+	robots := c.robotsFor(u)
+	if !c.config.IgnoreRobots && !robots.AllowedURL(c.config.UserAgent, u) {
+		page.State = "skipped"
+		page.Error = "disallowed by robots.txt"
+		return false
+	}
+
+	c.wait(robots.CrawlDelay(c.config.UserAgent))
+
+	req, err := http.NewRequest(http.MethodGet, page.URL, nil)
+	if err != nil {
+		page.State = "error"
+		page.Error = err.Error()
+		return false
+	}
+	req.Header.Set("User-Agent", c.config.UserAgent)
+
+	resp, err := c.client.Do(req)
+	page.CrawledAt = time.Now()
+	if err != nil {
+		page.State = "error"
+		page.Error = err.Error()
+		return true
+	}
+	defer resp.Body.Close()
+
+	page.StatusCode = resp.StatusCode
+	page.LastModified = resp.Header.Get("Last-Modified")
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		page.State = "error"
+		page.Error = fmt.Sprintf("status code %d", resp.StatusCode)
+		return true
+	}
+
+	// A redirected page is listed under its final URL instead
+	final := resp.Request.URL
+	if final.String() != page.URL {
+		page.State = "skipped"
+		page.Error = "redirected to " + final.String()
+		if err := c.enqueue(final, page.Depth); err != nil {
+			log.Printf("Error enqueueing %s: %v", final, err)
+		}
+		return true
+	}
+
+	page.State = "done"
+	if !strings.Contains(resp.Header.Get("Content-Type"), "html") {
+		return true
+	}
+
+	parsed, err := utils.ParseHTML(io.LimitReader(resp.Body, maxCrawlPageSize))
+	if err != nil {
+		log.Printf("Error parsing %s: %v", page.URL, err)
+	}
+	page.Title = parsed.Title
+	if canonical, ok := resolveLink(final, parsed.Canonical); ok {
+		page.Canonical = canonical.String()
+	}
+
+	if page.Depth >= c.config.MaxDepth {
+		return true
+	}
+	for _, href := range parsed.Links {
+		link, ok := resolveLink(final, href)
+		if !ok {
+			continue
+		}
+		if err := c.enqueue(link, page.Depth+1); err != nil {
+			log.Printf("Error enqueueing %s: %v", link, err)
+		}
+	}
+	return true
+}
+
+// enqueue adds a URL on one of the crawled hosts as a pending page, unless it is already known
+func (c *crawler) enqueue(u *url.URL, depth int) error {
+	if !c.hosts[strings.ToLower(u.Host)] {
+		return nil
+	}
+
+	var count int64
+	c.db.Model(&models.CrawlPage{}).Where("datasource_id = ? AND run = ? AND url = ?", c.datasource.ID, c.run, u.String()).Count(&count)
+	if count > 0 {
+		return nil
+	}
+
+	return c.db.Create(&models.CrawlPage{
+		DatasourceID: c.datasource.ID,
+		Run:          c.run,
+		URL:          u.String(),
+		Depth:        depth,
+		State:        "pending",
+	}).Error
+}
+
+// robotsFor returns the robots.txt rules of the URL's host, fetching them once per crawl
+func (c *crawler) robotsFor(u *url.URL) *utils.Robots {
+	host := strings.ToLower(u.Host)
+	if robots, ok := c.robots[host]; ok {
+		return robots
+	}
+
+	robots, err := utils.FetchRobots(c.client, c.config.UserAgent, u)
+	if err != nil {
+		log.Printf("Error fetching robots.txt for %s, crawling without it: %v", host, err)
+		robots = &utils.Robots{}
+	}
+	c.robots[host] = robots
+	return robots
+}
+
+// wait sleeps until the configured request rate (or the host's crawl delay, if longer) allows the next request
+func (c *crawler) wait(crawlDelay time.Duration) {
+	interval := crawlDelay
+	if c.config.RequestsPerSecond > 0 {
+		if perRequest := time.Duration(float64(time.Second) / c.config.RequestsPerSecond); perRequest > interval {
+			interval = perRequest
+		}
+	}
+
+	if sleep := time.Until(c.lastFetch.Add(interval)); sleep > 0 {
+		time.Sleep(sleep)
+	}
+	c.lastFetch = time.Now()
+}
+
+// resolveLink resolves an href against the page URL, keeping only http(s) links without fragments
+func resolveLink(base *url.URL, href string) (*url.URL, bool) {
+	if href == "" {
+		return nil, false
+	}
+	ref, err := url.Parse(href)
+	if err != nil {
+		return nil, false
+	}
+
+	link := base.ResolveReference(ref)
+	if link.Scheme != "http" && link.Scheme != "https" {
+		return nil, false
+	}
+	link.Fragment = ""
+	link.RawFragment = ""
+	link.Host = strings.ToLower(link.Host)
+	if link.Path == "" {
+		link.Path = "/"
+	}
+	return link, true
+}
+
+// crawlRowSource serves the pages of the datasource's last finished crawl as sitemap rows
+type crawlRowSource struct {
+	db           *gorm.DB
+	datasourceID uint
+	run          int
+}
+
+func newCrawlRowSource(db *gorm.DB, datasourceID uint) (*crawlRowSource, error) {
+	run, err := finishedCrawlRun(db, datasourceID)
+	if err != nil {
+		return nil, err
+	}
+	return &crawlRowSource{db: db, datasourceID: datasourceID, run: run}, nil
+}
+
+func (s *crawlRowSource) Fetch(limit, offset int) ([]map[string]interface{}, error) {
+	query := s.db.Where("datasource_id = ? AND run = ? AND state = ?", s.datasourceID, s.run, "done").Order("id").Offset(offset)
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+
+	var pages []models.CrawlPage
+	if err := query.Find(&pages).Error; err != nil {
+		return nil, err
+	}
+
+	rows := make([]map[string]interface{}, 0, len(pages))
+	for _, page := range pages {
+		rows = append(rows, map[string]interface{}{
+			"url":           page.URL,
+			"title":         page.Title,
+			"last_modified": page.LastModified,
+			"canonical":     page.Canonical,
+		})
+	}
+	return rows, nil
+}
+
+func (s *crawlRowSource) Close() error {
+	return nil
+}
@@ -0,0 +1,165 @@
+package services
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sitemap-builder/models"
+	"sitemap-builder/utils"
+	"strconv"
+	"strings"
+)
+
+// isNewsSitemap reports whether a sitemap type is a Google News sitemap. Types
+// are matched case-insensitively, as they are stored the way users entered them.
+func isNewsSitemap(sitemapType string) bool {
+	return strings.EqualFold(sitemapType, "news")
+}
+
+// newURLSet creates an empty URL set with the namespaces a sitemap type needs
+func newURLSet(sitemapType string) models.XMLURLSet {
+	urlSet := models.XMLURLSet{
+		XMLNS: "http://www.sitemaps.org/schemas/sitemap/0.9",
+		URLs:  []models.XMLURL{},
+	}
+	if isNewsSitemap(sitemapType) {
+		urlSet.XMLNSNews = "http://www.google.com/schemas/sitemap-news/0.9"
+	}
+	return urlSet
+}
+
+// buildURLEntry turns a datasource row into a sitemap entry. With overrides, the
+// row's lastmod, changefreq and priority replace the config's defaults. It also
+// returns warnings about row values that are missing, NULL or could not be parsed.
+func buildURLEntry(sitemap *models.Sitemap, rowData map[string]interface{}, overrides bool) (models.XMLURL, []string) {
+	config := sitemap.Config
+	var warnings []string
+
+	for _, name := range URLPlaceholders(config.URLPattern) {
+		value, ok := rowData[name]
+		if !ok {
+			warnings = append(warnings, fmt.Sprintf("missing value for placeholder {%s}", name))
+		} else if value == nil {
+			warnings = append(warnings, fmt.Sprintf("NULL value for placeholder {%s}", name))
+		}
+	}
+
+	entry := models.XMLURL{
+		Loc: utils.BuildURL(config.BaseURL, config.URLPattern, rowData),
+	}
+
+	if isNewsSitemap(sitemap.Type) {
+		if _, ok := utils.ParseDate(rowData["publication_date"]); !ok {
+			warnings = append(warnings, fmt.Sprintf("unparsable publication_date %v, using the current date", rowData["publication_date"]))
+		}
+		if rowData["title"] == nil {
+			warnings = append(warnings, "NULL or missing title")
+		}
+		if rowData["language"] == nil {
+			warnings = append(warnings, fmt.Sprintf("NULL or missing language, using %s", config.DefaultLanguage))
+		}
+
+		entry.News = &models.XMLNews{
+			Publication: models.XMLPublication{
+				Name:     config.PublicationName,
+				Language: utils.GetValueOrDefault(rowData["language"], config.DefaultLanguage),
+			},
+			PublicationDate: utils.FormatNewsDate(rowData["publication_date"]),
+			Title:           utils.GetValueOrDefault(rowData["title"], ""),
+		}
+		return entry, warnings
+	}
+
+	entry.ChangeFreq = config.ChangeFrequency
+	entry.Priority = config.Priority
+	if overrides {
+		warnings = append(warnings, applyRowOverrides(&entry, rowData)...)
+	}
+	return entry, warnings
+}
+
+// applyRowOverrides takes lastmod, changefreq and priority from the row when it has them
+func applyRowOverrides(entry *models.XMLURL, rowData map[string]interface{}) []string {
+	var warnings []string
+
+	if value, ok := rowData["lastmod"]; ok && value != nil {
+		if lastMod, ok := utils.FormatLastMod(value); ok {
+			entry.LastMod = lastMod
+		} else {
+			warnings = append(warnings, fmt.Sprintf("unparsable lastmod %v", value))
+		}
+	}
+	if value, ok := rowData["changefreq"]; ok && value != nil {
+		if changeFreq := utils.GetValueOrDefault(value, ""); utils.IsValidChangeFreq(changeFreq) {
+			entry.ChangeFreq = changeFreq
+		} else {
+			warnings = append(warnings, fmt.Sprintf("invalid changefreq %v", value))
+		}
+	}
+	if value, ok := rowData["priority"]; ok && value != nil {
+		if priority, err := strconv.ParseFloat(utils.GetValueOrDefault(value, ""), 64); err == nil && priority >= 0 && priority <= 1 {
+			entry.Priority = priority
+		} else {
+			warnings = append(warnings, fmt.Sprintf("invalid priority %v", value))
+		}
+	}
+	return warnings
+}
+
+// renderXML marshals sitemap XML the way it is published
+func renderXML(data interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeXML(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeXML encodes sitemap XML to w as it is generated
+func writeXML(w io.Writer, data interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(data); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// writeURLSet encodes a urlset to w one entry at a time, as entries emits them,
+// producing the same XML as writeXML does for a whole models.XMLURLSet
+func writeURLSet(w io.Writer, sitemapType string, entries func(emit func(models.XMLURL) error) error) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+
+	urlSet := newURLSet(sitemapType)
+	start := xml.StartElement{
+		Name: xml.Name{Local: "urlset"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "xmlns"}, Value: urlSet.XMLNS}},
+	}
+	if urlSet.XMLNSNews != "" {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "xmlns:news"}, Value: urlSet.XMLNSNews})
+	}
+	if err := encoder.EncodeToken(start); err != nil {
+		return err
+	}
+
+	urlElement := xml.StartElement{Name: xml.Name{Local: "url"}}
+	err := entries(func(entry models.XMLURL) error {
+		return encoder.EncodeElement(entry, urlElement)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := encoder.EncodeToken(start.End()); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"sitemap-builder/models"
+	"testing"
+)
+
+func TestBuildURLEntry(t *testing.T) {
+	sitemap := &models.Sitemap{Type: "standard", Config: models.SitemapConfig{
+		BaseURL:         "example.com",
+		URLPattern:      "/posts/{slug}",
+		ChangeFrequency: "weekly",
+		Priority:        0.5,
+	}}
+	row := map[string]interface{}{"slug": "hello", "changefreq": "daily", "priority": "0.9", "lastmod": "2024-05-01"}
+
+	// Rows of other datasources get the config's defaults
+	entry, warnings := buildURLEntry(sitemap, row, false)
+	if entry.ChangeFreq != "weekly" || entry.Priority != 0.5 || entry.LastMod != "" {
+		t.Errorf("entry without overrides = %+v, want the config's defaults", entry)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("warnings %q", warnings)
+	}
+
+	// Static URLs override them with their own values
+	entry, _ = buildURLEntry(sitemap, row, true)
+	if entry.ChangeFreq != "daily" || entry.Priority != 0.9 || entry.LastMod != "2024-05-01" {
+		t.Errorf("entry with overrides = %+v, want the row's values", entry)
+	}
+
+	// A row without values of its own keeps the defaults
+	entry, _ = buildURLEntry(sitemap, map[string]interface{}{"slug": "plain"}, true)
+	if entry.ChangeFreq != "weekly" || entry.Priority != 0.5 {
+		t.Errorf("entry of a row without overrides = %+v, want the config's defaults", entry)
+	}
+}
+
+func TestBuildNewsEntry(t *testing.T) {
+	sitemap := &models.Sitemap{Type: "News", Config: models.SitemapConfig{
+		BaseURL:         "example.com",
+		URLPattern:      "/news/{slug}",
+		PublicationName: "Example News",
+		DefaultLanguage: "en",
+		ChangeFrequency: "hourly",
+	}}
+	entry, _ := buildURLEntry(sitemap, map[string]interface{}{"slug": "a", "title": "A", "publication_date": "2024-05-01"}, false)
+	if entry.News == nil {
+		t.Fatalf("entry of a %q sitemap has no news element", sitemap.Type)
+	}
+	if entry.News.Publication.Language != "en" || entry.News.Title != "A" {
+		t.Errorf("news element = %+v", entry.News)
+	}
+	if entry.ChangeFreq != "" {
+		t.Errorf("news entry has changefreq %q", entry.ChangeFreq)
+	}
+	if urlSet := newURLSet(sitemap.Type); urlSet.XMLNSNews == "" {
+		t.Errorf("URL set of a %q sitemap has no news namespace", sitemap.Type)
+	}
+}
@@ -0,0 +1,221 @@
+package services
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sitemap-builder/models"
+	"sitemap-builder/storage"
+)
+
+// fanOutTarget is one storage target of a sitemap index and its outcome so far
+type fanOutTarget struct {
+	config         models.StorageConfig
+	store          storage.Storage
+	files          int
+	verified       int
+	verifyFailures int
+	err            error
+}
+
+// fanOutStorage publishes every file to all storage targets of a sitemap index.
+// A target that fails is not written to again; whether that fails the whole
+// publication depends on the index's failure policy.
+type fanOutStorage struct {
+	policy  string
+	targets []*fanOutTarget
+}
+
+// openFanOut opens the backends of the given storage configs. Backends that
+// cannot be opened are recorded as failed targets.
+func openFanOut(configs []models.StorageConfig, policy string) *fanOutStorage {
+	f := &fanOutStorage{policy: policy}
+	for _, config := range configs {
+		target := &fanOutTarget{config: config}
+		target.store, target.err = storage.Open(config)
+		if target.err != nil {
+			log.Printf("Error opening %s storage target %d: %v", config.Mode, config.ID, target.err)
+		}
+		f.targets = append(f.targets, target)
+	}
+	return f
+}
+
+// active returns the targets that have not failed
+func (f *fanOutStorage) active() []*fanOutTarget {
+	var active []*fanOutTarget
+	for _, target := range f.targets {
+		if target.err == nil {
+			active = append(active, target)
+		}
+	}
+	return active
+}
+
+// check applies the failure policy: with "degrade" publication only fails when
+// no target is left, otherwise any failed target fails it
+func (f *fanOutStorage) check() error {
+	var firstErr error
+	failed := 0
+	for _, target := range f.targets {
+		if target.err != nil {
+			failed++
+			if firstErr == nil {
+				firstErr = target.err
+			}
+		}
+	}
+
+	if len(f.targets) == 0 {
+		return errors.New("no storage targets")
+	}
+	if failed == 0 || (f.policy == "degrade" && failed < len(f.targets)) {
+		return nil
+	}
+	return fmt.Errorf("%d of %d storage targets failed: %w", failed, len(f.targets), firstErr)
+}
+
+// Put streams r to all active targets at once. A target that fails stops
+// receiving data without affecting the others. Once the failure policy has
+// failed the publication, nothing more is written.
+func (f *fanOutStorage) Put(ctx context.Context, key string, r io.Reader, opts storage.PutOptions) error {
+	if err := f.check(); err != nil {
+		return err
+	}
+	active := f.active()
+
+	writers := make([]*io.PipeWriter, len(active))
+	errs := make([]error, len(active))
+	done := make(chan int, len(active))
+	for i, target := range active {
+		pr, pw := io.Pipe()
+		writers[i] = pw
+		go func(i int, target *fanOutTarget) {
+			errs[i] = target.store.Put(ctx, key, pr, opts)
+			pr.CloseWithError(errs[i])
+			done <- i
+		}(i, target)
+	}
+
+	md5Hash, sha256Hash := md5.New(), sha256.New()
+	var size int64
+
+	buf := make([]byte, 32*1024)
+	var readErr error
+	for {
+		n, err := r.Read(buf)
+		md5Hash.Write(buf[:n])
+		sha256Hash.Write(buf[:n])
+		size += int64(n)
+		for i, pw := range writers {
+			if pw == nil || n == 0 {
+				continue
+			}
+			if _, err := pw.Write(buf[:n]); err != nil {
+				writers[i] = nil
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			readErr = err
+			break
+		}
+	}
+	for _, pw := range writers {
+		if pw != nil {
+			pw.CloseWithError(readErr)
+		}
+	}
+	for range active {
+		<-done
+	}
+
+	// A failed source is not the targets' fault
+	if readErr != nil {
+		return readErr
+	}
+	sums := publishedSums{
+		size:   size,
+		md5:    hex.EncodeToString(md5Hash.Sum(nil)),
+		sha256: hex.EncodeToString(sha256Hash.Sum(nil)),
+	}
+	for i, target := range active {
+		if errs[i] != nil {
+			target.err = fmt.Errorf("writing %s: %w", key, errs[i])
+			log.Printf("Error publishing to %s storage target %d: %v", target.config.Mode, target.config.ID, target.err)
+			continue
+		}
+		target.files++
+
+		if target.config.Verify == "" {
+			continue
+		}
+		if err := verifyObject(ctx, target.config, target.store, key, sums); err != nil {
+			target.verifyFailures++
+			target.err = fmt.Errorf("verifying %s: %w", key, err)
+			log.Printf("Error verifying %s storage target %d: %v", target.config.Mode, target.config.ID, target.err)
+			continue
+		}
+		target.verified++
+	}
+	return f.check()
+}
+
+// Delete removes the object from all active targets
+func (f *fanOutStorage) Delete(ctx context.Context, key string) error {
+	var errs []error
+	for _, target := range f.active() {
+		errs = append(errs, target.store.Delete(ctx, key))
+	}
+	return errors.Join(errs...)
+}
+
+// first returns the first active target, which reads are served from
+func (f *fanOutStorage) first() (storage.Storage, error) {
+	active := f.active()
+	if len(active) == 0 {
+		return nil, errors.New("no storage target available")
+	}
+	return active[0].store, nil
+}
+
+func (f *fanOutStorage) List(ctx context.Context, prefix string) ([]storage.ObjectInfo, error) {
+	store, err := f.first()
+	if err != nil {
+		return nil, err
+	}
+	return store.List(ctx, prefix)
+}
+
+func (f *fanOutStorage) Stat(ctx context.Context, key string) (storage.ObjectInfo, error) {
+	store, err := f.first()
+	if err != nil {
+		return storage.ObjectInfo{}, err
+	}
+	return store.Stat(ctx, key)
+}
+
+func (f *fanOutStorage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	store, err := f.first()
+	if err != nil {
+		return nil, err
+	}
+	return store.Open(ctx, key)
+}
+
+func (f *fanOutStorage) Close() error {
+	var errs []error
+	for _, target := range f.targets {
+		if target.store != nil {
+			errs = append(errs, target.store.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
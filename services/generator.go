@@ -1,12 +1,12 @@
 package services
 
 import (
-	"encoding/xml"
+	"context"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
-	"os"
 	"sitemap-builder/models"
+	"sitemap-builder/storage"
 	"sitemap-builder/utils"
 	"strings"
 	"time"
@@ -14,10 +14,13 @@ import (
 	"gorm.io/gorm"
 )
 
+// servedPath is the URL path the app serves published files under (see handlers/serve.go)
+const servedPath = "sitemaps"
+
 // GenerateAllSitemaps generates all sitemap indexes and their sitemaps
 func GenerateAllSitemaps(db *gorm.DB) {
 	var sitemapIndexes []models.SitemapIndex
-	db.Preload("Sitemaps.Config").Preload("StorageConfig").Find(&sitemapIndexes)
+	db.Preload("Sitemaps.Config").Preload("StorageConfigs").Find(&sitemapIndexes)
 	
 	for _, sitemapIndex := range sitemapIndexes {
 		err := GenerateSitemapIndex(db, &sitemapIndex)
@@ -27,40 +30,108 @@ func GenerateAllSitemaps(db *gorm.DB) {
 	}
 }
 
-// GenerateSitemapIndex generates a specific sitemap index and all its sitemaps
+// GenerateSitemapIndex generates a specific sitemap index and all its sitemaps,
+// publishes them to all of the index's storage targets and records the run
 func GenerateSitemapIndex(db *gorm.DB, sitemapIndex *models.SitemapIndex) error {
+	if !utils.IsSafeFileName(sitemapIndex.Name) {
+		return fmt.Errorf("invalid sitemap index name %q", sitemapIndex.Name)
+	}
+
+	run := models.GenerationRun{
+		SitemapIndexID: sitemapIndex.ID,
+		Status:         "running",
+		StartedAt:      time.Now(),
+	}
+	db.Create(&run)
+
+	store := openFanOut(indexStorageConfigs(sitemapIndex), sitemapIndex.FailurePolicy)
+	defer store.Close()
+
+	err := store.check()
+	if err == nil {
+		err = publishSitemapIndex(db, sitemapIndex, store)
+	}
+	finishRun(db, sitemapIndex, &run, store, err)
+	return err
+}
+
+// finishRun records the outcome of a run and of each of its storage targets,
+// alerting on files that failed verification
+func finishRun(db *gorm.DB, sitemapIndex *models.SitemapIndex, run *models.GenerationRun, store *fanOutStorage, err error) {
+	run.FinishedAt = time.Now()
+	run.Status = "success"
+	for _, target := range store.targets {
+		record := models.GenerationRunTarget{
+			StorageConfigID: target.config.ID,
+			Mode:            target.config.Mode,
+			Status:          "success",
+			Files:           target.files,
+			Verify:          target.config.Verify,
+			VerifiedFiles:   target.verified,
+			VerifyFailures:  target.verifyFailures,
+		}
+		if target.err != nil {
+			record.Status = "failed"
+			record.Error = target.err.Error()
+			run.Status = "degraded"
+		}
+		if target.verifyFailures > 0 {
+			sendAlert(fmt.Sprintf("Sitemap index %s: published file failed verification", sitemapIndex.Name), map[string]interface{}{
+				"sitemap_index_id":  sitemapIndex.ID,
+				"generation_run_id": run.ID,
+				"storage_config_id": target.config.ID,
+				"mode":              target.config.Mode,
+				"error":             record.Error,
+			})
+		}
+		run.Targets = append(run.Targets, record)
+	}
+	if err != nil {
+		run.Status = "failed"
+		run.Error = err.Error()
+	}
+	db.Save(run)
+}
+
+// publishSitemapIndex generates the sitemaps and the index file of a sitemap index
+func publishSitemapIndex(db *gorm.DB, sitemapIndex *models.SitemapIndex, store *fanOutStorage) error {
 	xmlIndex := models.XMLSitemapIndex{
 		XMLNS:    "http://www.sitemaps.org/schemas/sitemap/0.9",
 		Sitemaps: []models.XMLSitemap{},
 	}
-	
-	outputDir := "sitemaps"
-	os.MkdirAll(outputDir, os.ModePerm)
+	publicTarget := store.targets[0].config
 
 	for _, sitemap := range sitemapIndex.Sitemaps {
-		baseFilename := fmt.Sprintf("%s/%s", outputDir, sitemap.Name)
-		chunkFiles, err := GenerateSitemap(db, &sitemap, baseFilename, sitemapIndex)
+		if !utils.IsSafeFileName(sitemap.Name) {
+			log.Printf("Skipping sitemap with invalid name %q", sitemap.Name)
+			continue
+		}
+
+		chunkFiles, err := GenerateSitemap(db, &sitemap, sitemapDir(sitemapIndex.Name, sitemap.Name)+sitemap.Name, store)
 		if err != nil {
 			log.Printf("Error generating sitemap %s: %v", sitemap.Name, err)
 			continue
 		}
+		if err := removeStaleFiles(store, sitemapIndex.Name, sitemap.Name, chunkFiles); err != nil {
+			log.Printf("Error removing stale files of sitemap %s: %v", sitemap.Name, err)
+		}
 
 		for _, chunkFile := range chunkFiles {
 			xmlIndex.Sitemaps = append(xmlIndex.Sitemaps, models.XMLSitemap{
-				Loc:     fmt.Sprintf("https://%s/%s", sitemap.Config.BaseURL, chunkFile),
+				Loc:     publishedURL(publicTarget, sitemap.Config.BaseURL, chunkFile),
 				LastMod: time.Now().Format("2006-01-02"),
 			})
 		}
 
 		sitemap.LastGeneration = time.Now()
+		sitemap.Files = chunkFiles
 		if len(chunkFiles) > 0 {
-			sitemap.FilePath = chunkFiles[0]
+			sitemap.FilePath = storage.ObjectPath(publicTarget.Path, chunkFiles[0])
 		}
 		db.Save(&sitemap)
 	}
 
-	indexFilename := fmt.Sprintf("%s/%s.xml", outputDir, sitemapIndex.Name)
-	if err := writeXMLFile(xmlIndex, indexFilename, sitemapIndex.StorageConfig); err != nil {
+	if err := writeXMLFile(xmlIndex, indexFileKey(sitemapIndex.Name), store); err != nil {
 		return err
 	}
 
@@ -69,152 +140,195 @@ func GenerateSitemapIndex(db *gorm.DB, sitemapIndex *models.SitemapIndex) error
 	return nil
 }
 
-// GenerateSitemap generates a sitemap with chunking
-func GenerateSitemap(db *gorm.DB, sitemap *models.Sitemap, baseFilename string, sitemapIndex *models.SitemapIndex) ([]string, error) {
-	var generatedFiles []string
-	var datasource models.Datasource
-	if result := db.First(&datasource, sitemap.Config.DatasourceID); result.Error != nil {
-		return nil, result.Error
+// removeStaleFiles deletes the files in a sitemap's directory that the last
+// generation did not write, such as chunks beyond the current count
+func removeStaleFiles(store *fanOutStorage, indexName, sitemapName string, files []string) error {
+	ctx := context.Background()
+	current := make(map[string]bool, len(files))
+	for _, file := range files {
+		current[file] = true
+	}
+
+	objects, err := store.List(ctx, sitemapDir(indexName, sitemapName))
+	if err != nil {
+		return err
+	}
+	for _, object := range objects {
+		if current[object.Key] {
+			continue
+		}
+		log.Printf("Removing stale file %s", object.Key)
+		if err := store.Delete(ctx, object.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexFileKey is the storage key of a sitemap index's index file
+func indexFileKey(indexName string) string {
+	return indexName + ".xml"
+}
+
+// sitemapDir is the storage directory of a sitemap's files. Each index has a
+// directory of its own with one directory per sitemap, so indexes sharing a
+// storage path never overwrite each other's files.
+func sitemapDir(indexName, sitemapName string) string {
+	return indexName + "/" + sitemapName + "/"
+}
+
+// publishedURL returns the public URL of a published file: below the storage
+// target's public URL if it has one, otherwise where the app serves it itself
+func publishedURL(storageConfig models.StorageConfig, baseURL, key string) string {
+	if storageConfig.PublicURL != "" {
+		return strings.TrimSuffix(storageConfig.PublicURL, "/") + "/" + key
 	}
+	return fmt.Sprintf("https://%s/%s/%s", baseURL, servedPath, key)
+}
+
+// indexStorageConfigs returns the storage targets of a sitemap index, or the
+// default local one if it has none
+func indexStorageConfigs(sitemapIndex *models.SitemapIndex) []models.StorageConfig {
+	if len(sitemapIndex.StorageConfigs) == 0 {
+		return []models.StorageConfig{{Mode: "local", Path: "sitemaps/"}}
+	}
+	return sitemapIndex.StorageConfigs
+}
+
+const (
+	// urlsPerFile is how many URLs a sitemap file holds before the next one is started
+	urlsPerFile = 1000
+	// rowPageSize is how many rows are fetched from a datasource at a time
+	rowPageSize = 500
+)
 
+// GenerateSitemap generates a sitemap, split into files of urlsPerFile URLs
+// (news sitemaps are a single file). Rows are fetched a page at a time and
+// encoded into the upload as they arrive, so memory does not grow with the
+// number of rows.
+func GenerateSitemap(db *gorm.DB, sitemap *models.Sitemap, baseFilename string, store storage.Storage) ([]string, error) {
+	var generatedFiles []string
 
-	externalDB, err := utils.ConnectToDatasource(&datasource)
+	source, err := openRowSource(db, sitemap)
 	if err != nil {
 		return nil, err
 	}
+	defer source.Close()
 
-	sqlDB, err := externalDB.DB()
-    if err != nil {
-        return nil, err
-    }
-    defer sqlDB.Close()
+	rows := &rowPager{source: source}
+	overrides := hasRowOverrides(source)
+	baseFilename = strings.TrimSuffix(baseFilename, ".xml")
 
-	switch sitemap.Type {
-	case "news":
-		if strings.ToLower(sitemap.Type) == "news" {
-	
-			// Build a news sitemap without chunking
-			urlSet := models.XMLURLSet{
-				XMLNS:     "http://www.sitemaps.org/schemas/sitemap/0.9",
-				XMLNSNews: "http://www.google.com/schemas/sitemap-news/0.9",
-				URLs:      []models.XMLURL{},
-			}
-	
-			// Query all rows (adjust the query if needed)
-			query := fmt.Sprintf("%s", sitemap.Config.TableName)
-			rows, err := externalDB.Raw(query).Rows()
-			if err != nil {
-				return nil, err
-			}
-
-			for rows.Next() {
-				rowData, err := utils.ScanRowToMap(rows)
-				if err != nil {
-					rows.Close()
-					return nil, err
-				}
-	
-				url := utils.BuildURL(sitemap.Config.BaseURL, sitemap.Config.URLPattern, rowData)
-				newsEntry := models.XMLNews{
-					Publication: models.XMLPublication{
-						Name:     sitemap.Config.PublicationName,
-						Language: rowData["language"].(string),
-					},
-					PublicationDate: utils.FormatNewsDate(rowData["publication_date"]),
-					Title:           rowData["title"].(string),
-				}
-				urlSet.URLs = append(urlSet.URLs, models.XMLURL{
-					Loc:  url,
-					News: &newsEntry,
-				})
-			}
-			rows.Close()
-
-			// Build single file for news sitemap
-			newsFilename := fmt.Sprintf("%s.xml", strings.TrimSuffix(baseFilename, ".xml"))
-			if err := writeXMLFile(urlSet, newsFilename, sitemapIndex.StorageConfig); err != nil {
-				return nil, err
-			}
-			
-			generatedFiles = append(generatedFiles, newsFilename)
-			
-			return generatedFiles, nil
-		}
-	default:
-		const chunkSize = 1000
-		offset := 0
-		chunkNumber := 1
-
-		for {
-			query := fmt.Sprintf("%s LIMIT %d OFFSET %d", 
-				sitemap.Config.TableName, chunkSize, offset)
-			rows, err := externalDB.Raw(query).Rows()
-			if err != nil {
-				return generatedFiles, err
-			}
-
-			urlSet := models.XMLURLSet{
-				XMLNS: "http://www.sitemaps.org/schemas/sitemap/0.9",
-				URLs:  []models.XMLURL{},
-			}
-
-			rowCount := 0
-			for rows.Next() {
-				rowData, err := utils.ScanRowToMap(rows)
-				if err != nil {
-					rows.Close()
-					return generatedFiles, err
-				}
-
-				url := utils.BuildURL(sitemap.Config.BaseURL, 
-					sitemap.Config.URLPattern, rowData)
-				
-				urlSet.URLs = append(urlSet.URLs, models.XMLURL{
-					Loc:        url,
-					// LastMod:    time.Now().Format("2006-01-02"),
-					Priority:   sitemap.Config.Priority,
-				})
-				rowCount++
-			}
-			rows.Close()
-
-			if rowCount == 0 {
-				break
-			}
-
-			chunkFilename := fmt.Sprintf("%s-%04d.xml", 
-				strings.TrimSuffix(baseFilename, ".xml"), 
-				chunkNumber)
-
-			if err := writeXMLFile(urlSet, chunkFilename, sitemapIndex.StorageConfig); err != nil {
-				return generatedFiles, err
-			}
-
-			generatedFiles = append(generatedFiles, chunkFilename)
-			offset += rowCount
-			chunkNumber++
+	// Build a news sitemap without chunking, even if it has no rows
+	if isNewsSitemap(sitemap.Type) {
+		newsFilename := fmt.Sprintf("%s.xml", baseFilename)
+		err := writeURLSetFile(newsFilename, sitemap.Type, store, func(emit func(models.XMLURL) error) error {
+			return rows.each(0, func(rowData map[string]interface{}) error {
+				entry, _ := buildURLEntry(sitemap, rowData, overrides)
+				return emit(entry)
+			})
+		})
+		if err != nil {
+			return nil, err
+		}
+		return append(generatedFiles, newsFilename), nil
+	}
+
+	for chunkNumber := 1; ; chunkNumber++ {
+		more, err := rows.more()
+		if err != nil {
+			return generatedFiles, err
+		}
+		if !more {
+			break
 		}
 
+		chunkFilename := fmt.Sprintf("%s-%04d.xml", baseFilename, chunkNumber)
+		err = writeURLSetFile(chunkFilename, sitemap.Type, store, func(emit func(models.XMLURL) error) error {
+			return rows.each(urlsPerFile, func(rowData map[string]interface{}) error {
+				entry, _ := buildURLEntry(sitemap, rowData, overrides)
+				return emit(entry)
+			})
+		})
+		if err != nil {
+			return generatedFiles, err
+		}
+		generatedFiles = append(generatedFiles, chunkFilename)
 	}
 	return generatedFiles, nil
 }
 
-// Helper function to write XML files
-func writeXMLFile(data interface{}, filename string, storage models.StorageConfig) error {
-    xmlData, err := xml.MarshalIndent(data, "", "  ")
-    if err != nil {
-        return err
-    }
-    xmlData = append([]byte(xml.Header), xmlData...)
+// rowPager reads the rows of a source one page at a time
+type rowPager struct {
+	source rowSource
+	offset int
+	page   []map[string]interface{}
+	done   bool
+}
+
+// more reports whether the source has rows left, fetching the next page if needed
+func (p *rowPager) more() (bool, error) {
+	if len(p.page) == 0 && !p.done {
+		rows, err := p.source.Fetch(rowPageSize, p.offset)
+		if err != nil {
+			return false, err
+		}
+		p.offset += len(rows)
+		p.page = rows
+		p.done = len(rows) < rowPageSize
+	}
+	return len(p.page) > 0, nil
+}
+
+// each calls fn with up to limit of the remaining rows, or all of them if limit is 0
+func (p *rowPager) each(limit int, fn func(map[string]interface{}) error) error {
+	for n := 0; limit == 0 || n < limit; n++ {
+		more, err := p.more()
+		if err != nil {
+			return err
+		}
+		if !more {
+			return nil
+		}
+		row := p.page[0]
+		p.page = p.page[1:]
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-    if storage.Mode == "s3" {
-		log.Printf("Uploading to S3: %s", filename)
-        key := storage.Path + filename
-        return utils.UploadToS3(xmlData, storage.Bucket, key, storage.Region, storage.Endpoint, "application/xml")
-    }
-	log.Printf("Writing to local file: %s", filename)
+// writeURLSetFile streams a urlset whose entries are produced by entries to the store
+func writeURLSetFile(filename, sitemapType string, store storage.Storage, entries func(emit func(models.XMLURL) error) error) error {
+	return writeFile(filename, store, func(w io.Writer) error {
+		return writeURLSet(w, sitemapType, entries)
+	})
+}
 
-    // Local mode
-    return ioutil.WriteFile(filename, xmlData, 0644)
+// Helper function to write XML files
+func writeXMLFile(data interface{}, filename string, store storage.Storage) error {
+	return writeFile(filename, store, func(w io.Writer) error {
+		return writeXML(w, data)
+	})
 }
 
+// writeFile streams what write produces to the store as it is written, so
+// files are never held in memory as a whole
+func writeFile(filename string, store storage.Storage, write func(io.Writer) error) error {
+	log.Printf("Writing %s", filename)
+
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pw.CloseWithError(write(pw))
+	}()
+
+	err := store.Put(context.Background(), filename, pr, storage.PutOptions{ContentType: "application/xml"})
+	// Unblock the writer if the store stopped reading early, and wait for it
+	// to stop using the row source
+	pr.CloseWithError(err)
+	<-done
+	return err
+}
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"sitemap-builder/models"
+
+	"gorm.io/gorm"
+)
+
+// PreviewRow is a datasource row rendered as a sitemap entry
+type PreviewRow struct {
+	Row      int                    `json:"row"`
+	URL      string                 `json:"url"`
+	Data     map[string]interface{} `json:"data"`
+	Warnings []string               `json:"warnings"`
+}
+
+// Preview is a sample of the sitemap a config generates
+type Preview struct {
+	URLs         []string     `json:"urls"`
+	XML          string       `json:"xml"`
+	Rows         []PreviewRow `json:"rows"`
+	WarningCount int          `json:"warning_count"`
+}
+
+// PreviewSitemap renders up to limit rows of a sitemap's config exactly as
+// generation does, without crawling or publishing anything
+func PreviewSitemap(db *gorm.DB, sitemap *models.Sitemap, limit int) (*Preview, error) {
+	source, err := openRowSource(db, sitemap)
+	if err != nil {
+		return nil, err
+	}
+	defer source.Close()
+
+	rows, err := source.Fetch(limit, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	preview := &Preview{URLs: []string{}, Rows: []PreviewRow{}}
+	urlSet := newURLSet(sitemap.Type)
+	for i, rowData := range rows {
+		entry, warnings := buildURLEntry(sitemap, rowData, hasRowOverrides(source))
+		urlSet.URLs = append(urlSet.URLs, entry)
+
+		if warnings == nil {
+			warnings = []string{}
+		}
+		preview.URLs = append(preview.URLs, entry.Loc)
+		preview.Rows = append(preview.Rows, PreviewRow{
+			Row:      i + 1,
+			URL:      entry.Loc,
+			Data:     rowData,
+			Warnings: warnings,
+		})
+		preview.WarningCount += len(warnings)
+	}
+
+	xmlData, err := renderXML(urlSet)
+	if err != nil {
+		return nil, err
+	}
+	preview.XML = string(xmlData)
+	return preview, nil
+}
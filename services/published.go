@@ -0,0 +1,46 @@
+package services
+
+import (
+	"sitemap-builder/models"
+	"sitemap-builder/storage"
+	"sitemap-builder/utils"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// PublishedFileStorages returns the storage targets a published file was written
+// to. Files are addressed by their storage key, <index>.xml for an index file and
+// <index>/<sitemap>/<file>.xml for a sitemap file, so the index that owns a file is
+// known from its path. storage.ErrNotExist is returned for any other path.
+func PublishedFileStorages(db *gorm.DB, key string) ([]models.StorageConfig, error) {
+	if !strings.HasSuffix(key, ".xml") {
+		return nil, storage.ErrNotExist
+	}
+	segments := strings.Split(key, "/")
+	for _, segment := range segments {
+		if !utils.IsSafeFileName(segment) {
+			return nil, storage.ErrNotExist
+		}
+	}
+
+	var indexName string
+	switch len(segments) {
+	case 1:
+		indexName = strings.TrimSuffix(key, ".xml")
+	case 3:
+		indexName = segments[0]
+	default:
+		return nil, storage.ErrNotExist
+	}
+
+	// Index names are unique; the oldest index wins for names duplicated before that
+	var indexes []models.SitemapIndex
+	if err := db.Preload("StorageConfigs").Where("name = ?", indexName).Order("id").Limit(1).Find(&indexes).Error; err != nil {
+		return nil, err
+	}
+	if len(indexes) == 0 {
+		return nil, storage.ErrNotExist
+	}
+	return indexStorageConfigs(&indexes[0]), nil
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sitemap-builder/models"
+	"sitemap-builder/storage"
+	"sort"
+
+	"gorm.io/gorm"
+)
+
+// sitemapFiles lists the files published for the named sitemap of an index, in
+// chunk order. Every file in the sitemap's directory belongs to it.
+func sitemapFiles(ctx context.Context, store storage.Storage, indexName, name string) ([]storage.ObjectInfo, error) {
+	files, err := store.List(ctx, sitemapDir(indexName, name))
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Key < files[j].Key })
+	return files, nil
+}
+
+// purgeFiles deletes the published files of the named sitemaps, and the index
+// file if purgeIndex is set, from every storage target of a sitemap index
+func purgeFiles(sitemapIndex *models.SitemapIndex, purgeIndex bool, sitemapNames []string) (int, error) {
+	ctx := context.Background()
+	purged := 0
+	var errs []error
+
+	for _, config := range indexStorageConfigs(sitemapIndex) {
+		store, err := storage.Open(config)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s storage target %d: %w", config.Mode, config.ID, err))
+			continue
+		}
+
+		var keys []string
+		if purgeIndex {
+			keys = append(keys, indexFileKey(sitemapIndex.Name))
+		}
+		for _, name := range sitemapNames {
+			files, err := sitemapFiles(ctx, store, sitemapIndex.Name, name)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s storage target %d: %w", config.Mode, config.ID, err))
+				continue
+			}
+			for _, file := range files {
+				keys = append(keys, file.Key)
+			}
+		}
+
+		for _, key := range keys {
+			if err := store.Delete(ctx, key); err != nil {
+				errs = append(errs, fmt.Errorf("%s storage target %d: deleting %s: %w", config.Mode, config.ID, key, err))
+				continue
+			}
+			purged++
+		}
+		store.Close()
+	}
+	return purged, errors.Join(errs...)
+}
+
+// PurgeSitemap deletes the published files of a sitemap from every storage
+// target of its index and republishes the index file without them. It returns
+// the number of deleted files.
+func PurgeSitemap(db *gorm.DB, sitemap *models.Sitemap) (int, error) {
+	var sitemapIndex models.SitemapIndex
+	if err := db.Preload("Sitemaps.Config").Preload("StorageConfigs").Limit(1).Find(&sitemapIndex, sitemap.SitemapIndexID).Error; err != nil {
+		return 0, err
+	}
+	if sitemapIndex.ID == 0 {
+		return 0, nil
+	}
+
+	purged, err := purgeFiles(&sitemapIndex, false, []string{sitemap.Name})
+
+	// The index still lists the removed sitemap's files until it is republished
+	var remaining []models.Sitemap
+	for _, other := range sitemapIndex.Sitemaps {
+		if other.ID != sitemap.ID {
+			remaining = append(remaining, other)
+		}
+	}
+	sitemapIndex.Sitemaps = remaining
+	if republishErr := RepublishIndexFile(&sitemapIndex); republishErr != nil {
+		err = errors.Join(err, fmt.Errorf("republishing index: %w", republishErr))
+	}
+	return purged, err
+}
+
+// PurgeSitemapIndex deletes the index file and the files of all sitemaps of a
+// sitemap index from every storage target. It returns the number of deleted files.
+func PurgeSitemapIndex(db *gorm.DB, sitemapIndex *models.SitemapIndex) (int, error) {
+	if err := db.Preload("Sitemaps").Preload("StorageConfigs").Find(sitemapIndex, sitemapIndex.ID).Error; err != nil {
+		return 0, err
+	}
+
+	var names []string
+	for _, sitemap := range sitemapIndex.Sitemaps {
+		names = append(names, sitemap.Name)
+	}
+	return purgeFiles(sitemapIndex, true, names)
+}
+
+// RepublishIndexFile rewrites the index file of a sitemap index from the files
+// its sitemaps published in their last generation, without generating them.
+// Sitemaps generated before their files were recorded are listed from storage.
+func RepublishIndexFile(sitemapIndex *models.SitemapIndex) error {
+	xmlIndex := models.XMLSitemapIndex{
+		XMLNS:    "http://www.sitemaps.org/schemas/sitemap/0.9",
+		Sitemaps: []models.XMLSitemap{},
+	}
+
+	store := openFanOut(indexStorageConfigs(sitemapIndex), sitemapIndex.FailurePolicy)
+	defer store.Close()
+	if err := store.check(); err != nil {
+		return err
+	}
+
+	publicTarget := store.targets[0].config
+	for _, sitemap := range sitemapIndex.Sitemaps {
+		files := sitemap.Files
+		if len(files) == 0 {
+			objects, err := sitemapFiles(context.Background(), store, sitemapIndex.Name, sitemap.Name)
+			if err != nil {
+				return fmt.Errorf("listing files of sitemap %s: %w", sitemap.Name, err)
+			}
+			for _, object := range objects {
+				files = append(files, object.Key)
+			}
+		}
+		for _, file := range files {
+			xmlIndex.Sitemaps = append(xmlIndex.Sitemaps, models.XMLSitemap{
+				Loc:     publishedURL(publicTarget, sitemap.Config.BaseURL, file),
+				LastMod: sitemap.LastGeneration.Format("2006-01-02"),
+			})
+		}
+	}
+
+	return writeXMLFile(xmlIndex, indexFileKey(sitemapIndex.Name), store)
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"reflect"
+	"sitemap-builder/models"
+	"sitemap-builder/storage"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestRepublishIndexFile(t *testing.T) {
+	ctx := context.Background()
+	storageConfig := models.StorageConfig{Model: gorm.Model{ID: 2001}, Mode: "memory", PublicURL: "https://cdn.example.com/"}
+	store := storage.MemoryStore(storageConfig.ID)
+
+	// Sitemap "b" was generated before published files were recorded, so only
+	// its directory tells which files it has
+	for _, key := range []string{"a/b/b-0002.xml", "a/b/b-0001.xml", "a/c/c-0001.xml"} {
+		if err := store.Put(ctx, key, strings.NewReader("<urlset/>"), storage.PutOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lastGeneration := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	index := models.SitemapIndex{
+		Name:           "a",
+		StorageConfigs: []models.StorageConfig{storageConfig},
+		Sitemaps: []models.Sitemap{
+			{Name: "b", LastGeneration: lastGeneration},
+			{Name: "c", LastGeneration: lastGeneration, Files: []string{"a/c/c-0001.xml"}},
+		},
+	}
+	if err := RepublishIndexFile(&index); err != nil {
+		t.Fatalf("RepublishIndexFile: %v", err)
+	}
+
+	r, err := store.Open(ctx, "a.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var published models.XMLSitemapIndex
+	if err := xml.Unmarshal(data, &published); err != nil {
+		t.Fatalf("parsing index file: %v\n%s", err, data)
+	}
+
+	var locs []string
+	for _, sitemap := range published.Sitemaps {
+		locs = append(locs, sitemap.Loc)
+		if sitemap.LastMod != "2024-05-01" {
+			t.Errorf("lastmod of %s = %q, want 2024-05-01", sitemap.Loc, sitemap.LastMod)
+		}
+	}
+	want := []string{
+		"https://cdn.example.com/a/b/b-0001.xml",
+		"https://cdn.example.com/a/b/b-0002.xml",
+		"https://cdn.example.com/a/c/c-0001.xml",
+	}
+	if !reflect.DeepEqual(locs, want) {
+		t.Errorf("index lists %q, want %q", locs, want)
+	}
+}
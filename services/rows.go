@@ -0,0 +1,118 @@
+package services
+
+import (
+	"fmt"
+	"sitemap-builder/models"
+	"sitemap-builder/utils"
+
+	"gorm.io/gorm"
+)
+
+// rowSource provides the rows a sitemap is built from, whatever the datasource type
+type rowSource interface {
+	// Fetch returns up to limit rows starting at offset, or all rows if limit is 0
+	Fetch(limit, offset int) ([]map[string]interface{}, error)
+	Close() error
+}
+
+// openRowSource opens the datasource configured for a sitemap. Crawl datasources
+// serve the pages of their last finished crawl; crawls are started separately.
+func openRowSource(db *gorm.DB, sitemap *models.Sitemap) (rowSource, error) {
+	var datasource models.Datasource
+	if result := db.First(&datasource, sitemap.Config.DatasourceID); result.Error != nil {
+		return nil, result.Error
+	}
+
+	switch datasource.Type {
+	case "crawl":
+		return newCrawlRowSource(db, datasource.ID)
+	case "static":
+		return &staticRowSource{db: db, datasourceID: datasource.ID}, nil
+	default:
+		externalDB, err := utils.Pools.Get(&datasource)
+		if err != nil {
+			return nil, err
+		}
+		return &sqlRowSource{db: externalDB, query: sourceQuery(externalDB, sitemap.Config.TableName)}, nil
+	}
+}
+
+// hasRowOverrides reports whether a source's rows carry their own lastmod,
+// changefreq and priority. Only static URLs do; columns of those names in other
+// datasources are ordinary columns.
+func hasRowOverrides(source rowSource) bool {
+	_, ok := source.(*staticRowSource)
+	return ok
+}
+
+// sqlRowSource runs the configured query against an external database
+type sqlRowSource struct {
+	db    *gorm.DB
+	query string
+}
+
+func (s *sqlRowSource) Fetch(limit, offset int) ([]map[string]interface{}, error) {
+	query := s.query
+	if limit > 0 {
+		query = fmt.Sprintf("%s LIMIT %d OFFSET %d", s.query, limit, offset)
+	}
+
+	rows, err := s.db.Raw(query).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []map[string]interface{}
+	for rows.Next() {
+		rowData, err := utils.ScanRowToMap(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, rowData)
+	}
+	return result, rows.Err()
+}
+
+// Close leaves the connection open, it belongs to the datasource's shared pool
+func (s *sqlRowSource) Close() error {
+	return nil
+}
+
+// staticRowSource serves the URLs stored for a static datasource
+type staticRowSource struct {
+	db           *gorm.DB
+	datasourceID uint
+}
+
+func (s *staticRowSource) Fetch(limit, offset int) ([]map[string]interface{}, error) {
+	query := s.db.Where("datasource_id = ?", s.datasourceID).Order("id").Offset(offset)
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+
+	var staticURLs []models.StaticURL
+	if err := query.Find(&staticURLs).Error; err != nil {
+		return nil, err
+	}
+
+	rows := make([]map[string]interface{}, 0, len(staticURLs))
+	for _, staticURL := range staticURLs {
+		row := map[string]interface{}{"url": staticURL.Loc}
+		if staticURL.LastMod != "" {
+			row["lastmod"] = staticURL.LastMod
+		}
+		if staticURL.Priority != 0 {
+			row["priority"] = staticURL.Priority
+		}
+		if staticURL.ChangeFreq != "" {
+			row["changefreq"] = staticURL.ChangeFreq
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func (s *staticRowSource) Close() error {
+	return nil
+}
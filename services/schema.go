@@ -0,0 +1,187 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"sitemap-builder/models"
+	"sitemap-builder/utils"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// ColumnInfo describes a column of a datasource table
+type ColumnInfo struct {
+	Name       string        `json:"name"`
+	Type       string        `json:"type"`
+	Nullable   bool          `json:"nullable"`
+	PrimaryKey bool          `json:"primary_key"`
+	Samples    []interface{} `json:"samples"`
+}
+
+// Crawl and static datasources expose their rows as a single virtual table
+var virtualTables = map[string]struct {
+	table   string
+	columns []string
+}{
+	"crawl":  {table: "pages", columns: []string{"url", "title", "last_modified", "canonical", "lastmod"}},
+	"static": {table: "urls", columns: []string{"url", "lastmod", "priority", "changefreq"}},
+}
+
+// newsColumns are the columns a news sitemap reads besides the URL pattern placeholders
+var newsColumns = []string{"language", "title", "publication_date"}
+
+var (
+	identifier  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+	placeholder = regexp.MustCompile(`\{([^{}]+)\}`)
+)
+
+// sourceQuery returns the query for a config's TableName, which is either a
+// table name or a SELECT statement
+func sourceQuery(db *gorm.DB, tableName string) string {
+	tableName = strings.TrimSpace(tableName)
+	if !identifier.MatchString(tableName) {
+		return tableName
+	}
+
+	var quoted strings.Builder
+	db.Dialector.QuoteTo(&quoted, tableName)
+	return "SELECT * FROM " + quoted.String()
+}
+
+// URLPlaceholders returns the column names referenced as {placeholders} in a URL pattern
+func URLPlaceholders(pattern string) []string {
+	var names []string
+	for _, match := range placeholder.FindAllStringSubmatch(pattern, -1) {
+		names = append(names, match[1])
+	}
+	return names
+}
+
+// DatasourceTables lists the tables of a datasource
+func DatasourceTables(db *gorm.DB, datasource *models.Datasource) ([]string, error) {
+	if virtual, ok := virtualTables[datasource.Type]; ok {
+		return []string{virtual.table}, nil
+	}
+
+	externalDB, err := utils.Pools.Get(datasource)
+	if err != nil {
+		return nil, err
+	}
+	tables, err := externalDB.Migrator().GetTables()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(tables)
+	return tables, nil
+}
+
+// DatasourceColumns describes the columns of a datasource table, with up to sampleSize sample values each
+func DatasourceColumns(db *gorm.DB, datasource *models.Datasource, table string, sampleSize int) ([]ColumnInfo, error) {
+	tables, err := DatasourceTables(db, datasource)
+	if err != nil {
+		return nil, err
+	}
+	found := false
+	for _, name := range tables {
+		found = found || name == table
+	}
+	if !found {
+		return nil, fmt.Errorf("table %s not found", table)
+	}
+
+	var columns []ColumnInfo
+	var sampleRows []map[string]interface{}
+
+	if virtual, ok := virtualTables[datasource.Type]; ok {
+		for _, name := range virtual.columns {
+			columns = append(columns, ColumnInfo{Name: name, Type: "text", Nullable: true})
+		}
+		var source rowSource = &staticRowSource{db: db, datasourceID: datasource.ID}
+		if datasource.Type == "crawl" {
+			source = &crawlRowSource{db: db, datasourceID: datasource.ID}
+		}
+		if sampleRows, err = source.Fetch(sampleSize, 0); err != nil {
+			return nil, err
+		}
+	} else {
+		externalDB, err := utils.Pools.Get(datasource)
+		if err != nil {
+			return nil, err
+		}
+		columnTypes, err := externalDB.Migrator().ColumnTypes(table)
+		if err != nil {
+			return nil, err
+		}
+		for _, columnType := range columnTypes {
+			column := ColumnInfo{Name: columnType.Name(), Type: strings.ToLower(columnType.DatabaseTypeName())}
+			column.Nullable, _ = columnType.Nullable()
+			column.PrimaryKey, _ = columnType.PrimaryKey()
+			columns = append(columns, column)
+		}
+
+		sampleRows, err = (&sqlRowSource{db: externalDB, query: sourceQuery(externalDB, table)}).Fetch(sampleSize, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range columns {
+		columns[i].Samples = []interface{}{}
+		for _, row := range sampleRows {
+			if value, ok := row[columns[i].Name]; ok && value != nil {
+				columns[i].Samples = append(columns[i].Samples, value)
+			}
+		}
+	}
+	return columns, nil
+}
+
+// ConfigColumns returns the column names the rows of a config's query will have
+func ConfigColumns(db *gorm.DB, datasource *models.Datasource, config *models.SitemapConfig) ([]string, error) {
+	if virtual, ok := virtualTables[datasource.Type]; ok {
+		return virtual.columns, nil
+	}
+
+	externalDB, err := utils.Pools.Get(datasource)
+	if err != nil {
+		return nil, err
+	}
+
+	// Run the query without fetching any rows, just to learn its columns
+	query := fmt.Sprintf("SELECT * FROM (%s) AS q LIMIT 0", sourceQuery(externalDB, config.TableName))
+	rows, err := externalDB.Raw(query).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return rows.Columns()
+}
+
+// MissingConfigColumns checks that every {placeholder} in the config's URL pattern,
+// and every column a sitemap of the given type reads, exists in the config's query
+func MissingConfigColumns(db *gorm.DB, datasource *models.Datasource, config *models.SitemapConfig, sitemapType string) ([]string, error) {
+	columns, err := ConfigColumns(db, datasource, config)
+	if err != nil {
+		return nil, err
+	}
+	available := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		available[column] = true
+	}
+
+	required := URLPlaceholders(config.URLPattern)
+	if isNewsSitemap(sitemapType) {
+		required = append(required, newsColumns...)
+	}
+
+	var missing []string
+	for _, column := range required {
+		if !available[column] {
+			missing = append(missing, column)
+			available[column] = true // report each column once
+		}
+	}
+	return missing, nil
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"fmt"
+	"sitemap-builder/models"
+	"sitemap-builder/secrets"
+
+	"gorm.io/gorm"
+)
+
+// encryptedColumn is a column whose values are encrypted with the secrets master key
+type encryptedColumn struct {
+	model  interface{}
+	column string
+}
+
+// encryptedColumns lists every column holding secrets: the models.EncryptedString
+// fields and the validation headers
+var encryptedColumns = []encryptedColumn{
+	{&models.Datasource{}, "connection_string"},
+	{&models.StorageConfig{}, "secret_access_key"},
+	{&models.StorageConfig{}, "password"},
+	{&models.StorageConfig{}, "private_key"},
+	{&models.ValidationJob{}, "option_headers"},
+}
+
+// RotateSecretKeys encrypts secrets still stored in plaintext and re-wraps those
+// encrypted under a previous master key with the active one, in every encrypted
+// column. It returns the number of values updated.
+func RotateSecretKeys(db *gorm.DB) (int, error) {
+	if !secrets.Enabled() {
+		return 0, nil
+	}
+
+	rotated := 0
+	for _, column := range encryptedColumns {
+		n, err := rotateColumn(db, column.model, column.column)
+		rotated += n
+		if err != nil {
+			return rotated, err
+		}
+	}
+	return rotated, nil
+}
+
+// rotateColumn rotates the values of one encrypted column
+func rotateColumn(db *gorm.DB, model interface{}, column string) (int, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return 0, err
+	}
+	table := stmt.Schema.Table
+
+	// Read the stored values as-is, without decrypting them
+	var stored []struct {
+		ID    uint
+		Value string
+	}
+	err := db.Unscoped().Model(model).Select("id, " + column + " AS value").Where(column + " <> ''").Scan(&stored).Error
+	if err != nil {
+		return 0, fmt.Errorf("%s.%s: %v", table, column, err)
+	}
+
+	rotated := 0
+	for _, row := range stored {
+		if !secrets.NeedsRotation(row.Value) {
+			continue
+		}
+		value, err := secrets.Rotate(row.Value)
+		if err != nil {
+			return rotated, fmt.Errorf("%s %d %s: %v", table, row.ID, column, err)
+		}
+		err = db.Unscoped().Model(model).Where("id = ?", row.ID).UpdateColumn(column, value).Error
+		if err != nil {
+			return rotated, fmt.Errorf("%s %d %s: %v", table, row.ID, column, err)
+		}
+		rotated++
+	}
+	return rotated, nil
+}
@@ -0,0 +1,97 @@
+package services
+
+import (
+	"encoding/base64"
+	"os"
+	"sitemap-builder/models"
+	"sitemap-builder/secrets"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// useMasterKeys loads the given master keys for the duration of a test
+func useMasterKeys(t *testing.T, active string, previous ...string) {
+	t.Helper()
+	t.Setenv("SECRETS_MASTER_KEY", active)
+	t.Setenv("SECRETS_PREVIOUS_MASTER_KEYS", strings.Join(previous, ","))
+	if err := secrets.LoadKeys(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Unsetenv("SECRETS_MASTER_KEY")
+		os.Unsetenv("SECRETS_PREVIOUS_MASTER_KEYS")
+		secrets.LoadKeys()
+	})
+}
+
+func TestRotateSecretKeys(t *testing.T) {
+	keyA := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("a", 32)))
+	keyB := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("b", 32)))
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.Datasource{}, &models.StorageConfig{}, &models.ValidationJob{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Secrets stored before a master key was configured
+	db.Create(&models.Datasource{Name: "db", Type: "pgsql", ConnectionString: "postgres://app:secret@db/app"})
+	db.Create(&models.StorageConfig{Mode: "sftp", Password: "sftp-password", PrivateKey: "private-key"})
+	db.Create(&models.StorageConfig{Mode: "s3", SecretAccessKey: "s3-secret"})
+	db.Create(&models.ValidationJob{JobID: "job", Options: models.ValidationOptions{Headers: models.ValidationHeaders{"Authorization": "Bearer token"}}})
+
+	stored := func() []string {
+		var values []string
+		for _, column := range encryptedColumns {
+			var columnValues []string
+			db.Model(column.model).Where(column.column+" <> ''").Pluck(column.column, &columnValues)
+			values = append(values, columnValues...)
+		}
+		return values
+	}
+
+	useMasterKeys(t, keyA)
+	rotated, err := RotateSecretKeys(db)
+	if err != nil {
+		t.Fatalf("RotateSecretKeys: %v", err)
+	}
+	if rotated != 5 {
+		t.Errorf("encrypted %d plaintext secrets, want 5", rotated)
+	}
+	for _, value := range stored() {
+		if !secrets.IsEncrypted(value) {
+			t.Errorf("secret %q is still stored in plaintext", value)
+		}
+	}
+
+	// Rotate to key B, then drop key A
+	useMasterKeys(t, keyB, keyA)
+	if rotated, err := RotateSecretKeys(db); err != nil || rotated != 5 {
+		t.Fatalf("RotateSecretKeys = %d, %v, want 5 rotated", rotated, err)
+	}
+	if rotated, err := RotateSecretKeys(db); err != nil || rotated != 0 {
+		t.Errorf("second RotateSecretKeys = %d, %v, want nothing left to rotate", rotated, err)
+	}
+	useMasterKeys(t, keyB)
+
+	var datasource models.Datasource
+	if err := db.First(&datasource).Error; err != nil || datasource.ConnectionString != "postgres://app:secret@db/app" {
+		t.Errorf("datasource = %q, %v", datasource.ConnectionString, err)
+	}
+	var configs []models.StorageConfig
+	if err := db.Order("id").Find(&configs).Error; err != nil {
+		t.Fatalf("reading storage configs: %v", err)
+	}
+	if configs[0].Password != "sftp-password" || configs[0].PrivateKey != "private-key" || configs[1].SecretAccessKey != "s3-secret" {
+		t.Errorf("storage configs = %+v", configs)
+	}
+	var job models.ValidationJob
+	if err := db.First(&job).Error; err != nil || job.Options.Headers["Authorization"] != "Bearer token" {
+		t.Errorf("validation headers = %v, %v", job.Options.Headers, err)
+	}
+}
@@ -0,0 +1,250 @@
+package services
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sitemap-builder/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limits and namespaces of the sitemap protocol (https://www.sitemaps.org/protocol.html)
+// and of Google News sitemaps
+const (
+	sitemapNamespace  = "http://www.sitemaps.org/schemas/sitemap/0.9"
+	newsNamespace     = "http://www.google.com/schemas/sitemap-news/0.9"
+	maxSitemapURLs    = 50000
+	maxSitemapBytes   = 50 * 1024 * 1024
+	maxLocLength      = 2048
+	maxNewsURLs       = 1000
+	maxNewsArticleAge = 48 * time.Hour
+	maxIndexDepth     = 10
+)
+
+var validChangeFreqs = map[string]bool{
+	"always": true, "hourly": true, "daily": true, "weekly": true,
+	"monthly": true, "yearly": true, "never": true,
+}
+
+// newsLanguagePattern matches ISO 639 language codes, plus the zh-cn and zh-tw
+// codes Google News requires for Chinese
+var newsLanguagePattern = regexp.MustCompile(`^([a-z]{2,3}|zh-cn|zh-tw)$`)
+
+// w3cDateLayouts are the W3C Datetime formats allowed for lastmod and news:publication_date.
+// time.RFC3339 also accepts fractional seconds.
+var w3cDateLayouts = []string{"2006", "2006-01", "2006-01-02", "2006-01-02T15:04Z07:00", time.RFC3339}
+
+// The parse structs keep every value as text and record element namespaces, so that
+// invalid values and namespaces are reported as issues instead of failing the parse
+type parsedSitemapIndex struct {
+	XMLName  xml.Name        `xml:"sitemapindex"`
+	Sitemaps []parsedSitemap `xml:"sitemap"`
+}
+
+type parsedSitemap struct {
+	XMLName xml.Name
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+type parsedURLSet struct {
+	XMLName xml.Name    `xml:"urlset"`
+	URLs    []parsedURL `xml:"url"`
+}
+
+type parsedURL struct {
+	XMLName    xml.Name
+	Loc        string      `xml:"loc"`
+	LastMod    string      `xml:"lastmod"`
+	ChangeFreq string      `xml:"changefreq"`
+	Priority   *string     `xml:"priority"`
+	News       *parsedNews `xml:"news"`
+}
+
+type parsedNews struct {
+	XMLName         xml.Name
+	Publication     parsedPublication `xml:"publication"`
+	PublicationDate string            `xml:"publication_date"`
+	Title           string            `xml:"title"`
+}
+
+type parsedPublication struct {
+	Name     string `xml:"name"`
+	Language string `xml:"language"`
+}
+
+// issueList collects the issues found in one file
+type issueList []models.ValidationIssue
+
+func (l *issueList) add(severity, code, url, format string, args ...interface{}) {
+	*l = append(*l, models.ValidationIssue{
+		URL:      url,
+		Code:     code,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// checkSitemapIndex checks a sitemap index file against the sitemap protocol
+func checkSitemapIndex(index *parsedSitemapIndex, size int, sitemapURL string) []models.ValidationIssue {
+	var issues issueList
+	checkNamespace(&issues, index.XMLName)
+	checkLimits(&issues, len(index.Sitemaps), size)
+	base, _ := url.Parse(sitemapURL)
+
+	for _, sitemap := range index.Sitemaps {
+		// A wrong root namespace is reported once, not for every entry inheriting it
+		if sitemap.XMLName.Space != index.XMLName.Space {
+			issues.add("error", "invalid_namespace", sitemap.Loc, "<sitemap> element is in namespace %q instead of %q", sitemap.XMLName.Space, sitemapNamespace)
+		}
+		checkLoc(&issues, sitemap.Loc, base)
+		checkLastMod(&issues, sitemap.Loc, sitemap.LastMod)
+	}
+	return issues
+}
+
+// checkURLSet checks a sitemap file against the sitemap protocol and, for URLs
+// with news tags, the Google News sitemap rules
+func checkURLSet(urlset *parsedURLSet, size int, sitemapURL string) []models.ValidationIssue {
+	var issues issueList
+	checkNamespace(&issues, urlset.XMLName)
+	checkLimits(&issues, len(urlset.URLs), size)
+	base, _ := url.Parse(sitemapURL)
+
+	newsURLs := 0
+	for _, entry := range urlset.URLs {
+		if entry.XMLName.Space != urlset.XMLName.Space {
+			issues.add("error", "invalid_namespace", entry.Loc, "<url> element is in namespace %q instead of %q", entry.XMLName.Space, sitemapNamespace)
+		}
+		checkLoc(&issues, entry.Loc, base)
+		checkLastMod(&issues, entry.Loc, entry.LastMod)
+
+		if entry.ChangeFreq != "" && !validChangeFreqs[strings.TrimSpace(entry.ChangeFreq)] {
+			issues.add("error", "invalid_changefreq", entry.Loc, "changefreq %q is not one of always, hourly, daily, weekly, monthly, yearly or never", entry.ChangeFreq)
+		}
+		if entry.Priority != nil {
+			priority, err := strconv.ParseFloat(strings.TrimSpace(*entry.Priority), 64)
+			if err != nil || priority < 0 || priority > 1 {
+				issues.add("error", "invalid_priority", entry.Loc, "priority %q is not a number between 0.0 and 1.0", *entry.Priority)
+			}
+		}
+		if entry.News != nil {
+			newsURLs++
+			checkNews(&issues, entry.Loc, entry.News)
+		}
+	}
+
+	if newsURLs > maxNewsURLs {
+		issues.add("error", "news_too_many_urls", "", "news sitemap lists %d articles, the limit is %d", newsURLs, maxNewsURLs)
+	}
+	return issues
+}
+
+// checkTextSitemap checks a text sitemap, which lists one URL per line
+func checkTextSitemap(locs []string, size int, sitemapURL string) []models.ValidationIssue {
+	var issues issueList
+	checkLimits(&issues, len(locs), size)
+	base, _ := url.Parse(sitemapURL)
+
+	for _, loc := range locs {
+		checkLoc(&issues, loc, base)
+	}
+	return issues
+}
+
+// checkNamespace checks the namespace of the root element of a sitemap index or sitemap
+func checkNamespace(issues *issueList, root xml.Name) {
+	if root.Space != sitemapNamespace {
+		issues.add("error", "invalid_namespace", "", "<%s> element is in namespace %q instead of %q", root.Local, root.Space, sitemapNamespace)
+	}
+}
+
+// checkLimits checks the number of entries and the size of a sitemap index or sitemap
+func checkLimits(issues *issueList, entries, size int) {
+	if entries == 0 {
+		issues.add("warning", "empty_file", "", "file lists no entries")
+	}
+	if entries > maxSitemapURLs {
+		issues.add("error", "too_many_urls", "", "file lists %d entries, the limit is %d", entries, maxSitemapURLs)
+	}
+	if size > maxSitemapBytes {
+		issues.add("error", "file_too_large", "", "file is %d bytes uncompressed, the limit is %d", size, maxSitemapBytes)
+	}
+}
+
+// checkLoc checks that a loc is an absolute URL within the length limit on the host of its sitemap
+func checkLoc(issues *issueList, loc string, base *url.URL) {
+	loc = strings.TrimSpace(loc)
+	if loc == "" {
+		issues.add("error", "missing_loc", "", "entry has no loc")
+		return
+	}
+	if len(loc) > maxLocLength {
+		issues.add("error", "loc_too_long", loc, "loc is %d characters long, the limit is %d", len(loc), maxLocLength)
+	}
+
+	u, err := url.Parse(loc)
+	if err != nil || !u.IsAbs() || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		issues.add("error", "invalid_loc", loc, "loc is not an absolute http or https URL")
+		return
+	}
+	// URLs on other hosts are allowed when the other host's robots.txt references the sitemap.
+	// Files read from storage have no host to compare with.
+	if base != nil && base.Hostname() != "" && !strings.EqualFold(u.Hostname(), base.Hostname()) {
+		issues.add("warning", "host_mismatch", loc, "loc is on host %s, the sitemap on %s", u.Hostname(), base.Hostname())
+	}
+}
+
+// checkLastMod checks that a lastmod, if given, is a W3C Datetime that is not in the future
+func checkLastMod(issues *issueList, loc, lastMod string) {
+	if lastMod == "" {
+		return
+	}
+	date, ok := parseW3CDate(lastMod)
+	if !ok {
+		issues.add("error", "invalid_lastmod", loc, "lastmod %q is not a W3C Datetime", lastMod)
+		return
+	}
+	if date.After(time.Now().Add(24 * time.Hour)) {
+		issues.add("warning", "lastmod_in_future", loc, "lastmod %q is in the future", lastMod)
+	}
+}
+
+// checkNews checks the news tags of a URL against the Google News sitemap rules
+func checkNews(issues *issueList, loc string, news *parsedNews) {
+	if news.XMLName.Space != newsNamespace {
+		issues.add("error", "news_invalid_namespace", loc, "<news> element is in namespace %q instead of %q", news.XMLName.Space, newsNamespace)
+	}
+	if strings.TrimSpace(news.Publication.Name) == "" {
+		issues.add("error", "news_missing_publication_name", loc, "news:publication has no news:name")
+	}
+	if !newsLanguagePattern.MatchString(strings.TrimSpace(news.Publication.Language)) {
+		issues.add("error", "news_invalid_language", loc, "news:language %q is not an ISO 639 language code", news.Publication.Language)
+	}
+	if strings.TrimSpace(news.Title) == "" {
+		issues.add("error", "news_missing_title", loc, "news:news has no news:title")
+	}
+
+	date, ok := parseW3CDate(news.PublicationDate)
+	if !ok {
+		issues.add("error", "news_invalid_publication_date", loc, "news:publication_date %q is not a W3C Datetime", news.PublicationDate)
+		return
+	}
+	if time.Since(date) > maxNewsArticleAge {
+		issues.add("warning", "news_article_too_old", loc, "article was published on %s, news sitemaps should only list articles from the last two days", news.PublicationDate)
+	}
+}
+
+// parseW3CDate parses a date in one of the W3C Datetime formats
+func parseW3CDate(value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	for _, layout := range w3cDateLayouts {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date, true
+		}
+	}
+	return time.Time{}, false
+}
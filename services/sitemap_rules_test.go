@@ -0,0 +1,179 @@
+package services
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sitemap-builder/models"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// issueCodes returns the sorted codes of issues
+func issueCodes(issues []models.ValidationIssue) []string {
+	codes := []string{}
+	for _, issue := range issues {
+		codes = append(codes, issue.Code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+func urlset(entries string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9" xmlns:news="http://www.google.com/schemas/sitemap-news/0.9">` + entries + `</urlset>`
+}
+
+func newsEntry(name, language, title, date string) string {
+	return fmt.Sprintf(`<url><loc>https://example.com/article</loc><news:news><news:publication><news:name>%s</news:name><news:language>%s</news:language></news:publication><news:publication_date>%s</news:publication_date><news:title>%s</news:title></news:news></url>`,
+		name, language, date, title)
+}
+
+func TestCheckURLSet(t *testing.T) {
+	recent := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	old := time.Now().Add(-72 * time.Hour).UTC().Format(time.RFC3339)
+	future := time.Now().Add(72 * time.Hour).UTC().Format("2006-01-02")
+
+	tests := []struct {
+		name string
+		xml  string
+		want []string
+	}{
+		{"valid", urlset(`<url><loc>https://example.com/</loc><lastmod>2024-05-01</lastmod><changefreq>daily</changefreq><priority>0.8</priority></url>
+			<url><loc> https://example.com/page?a=1&amp;b=2 </loc><lastmod>2024-05-01T10:00:00+02:00</lastmod><priority> 1.0 </priority></url>`), nil},
+		{"empty", urlset(""), []string{"empty_file"}},
+		{"wrong root namespace", `<urlset xmlns="http://www.google.com/schemas/sitemap/0.84"><url><loc>https://example.com/</loc></url></urlset>`, []string{"invalid_namespace"}},
+		{"no namespace", `<urlset><url><loc>https://example.com/</loc></url></urlset>`, []string{"invalid_namespace"}},
+		{"entry in another namespace", urlset(`<url xmlns="http://example.com/ns"><loc>https://example.com/</loc></url>`), []string{"invalid_namespace"}},
+		{"missing loc", urlset(`<url><lastmod>2024-05-01</lastmod></url>`), []string{"missing_loc"}},
+		{"relative loc", urlset(`<url><loc>/page</loc></url>`), []string{"invalid_loc"}},
+		{"ftp loc", urlset(`<url><loc>ftp://example.com/file</loc></url>`), []string{"invalid_loc"}},
+		{"long loc", urlset(`<url><loc>https://example.com/` + strings.Repeat("a", maxLocLength) + `</loc></url>`), []string{"loc_too_long"}},
+		{"other host", urlset(`<url><loc>https://other.example.org/</loc></url>`), []string{"host_mismatch"}},
+		{"host case", urlset(`<url><loc>https://EXAMPLE.com/</loc></url>`), nil},
+		{"invalid lastmod", urlset(`<url><loc>https://example.com/</loc><lastmod>05/01/2024</lastmod></url>`), []string{"invalid_lastmod"}},
+		{"future lastmod", urlset(`<url><loc>https://example.com/</loc><lastmod>` + future + `</lastmod></url>`), []string{"lastmod_in_future"}},
+		{"invalid changefreq", urlset(`<url><loc>https://example.com/</loc><changefreq>sometimes</changefreq></url>`), []string{"invalid_changefreq"}},
+		{"priority above 1", urlset(`<url><loc>https://example.com/</loc><priority>1.5</priority></url>`), []string{"invalid_priority"}},
+		{"priority not a number", urlset(`<url><loc>https://example.com/</loc><priority>high</priority></url>`), []string{"invalid_priority"}},
+		{"empty priority", urlset(`<url><loc>https://example.com/</loc><priority></priority></url>`), []string{"invalid_priority"}},
+		{"several issues", urlset(`<url><loc>page</loc><lastmod>yesterday</lastmod><priority>-1</priority></url>`), []string{"invalid_lastmod", "invalid_loc", "invalid_priority"}},
+
+		{"news", urlset(newsEntry("Example News", "en", "Title", recent)), nil},
+		{"news chinese", urlset(newsEntry("Example News", "zh-tw", "Title", recent)), nil},
+		{"news without publication name", urlset(newsEntry(" ", "en", "Title", recent)), []string{"news_missing_publication_name"}},
+		{"news with invalid language", urlset(newsEntry("Example News", "English", "Title", recent)), []string{"news_invalid_language"}},
+		{"news without title", urlset(newsEntry("Example News", "en", "", recent)), []string{"news_missing_title"}},
+		{"news with invalid date", urlset(newsEntry("Example News", "en", "Title", "today")), []string{"news_invalid_publication_date"}},
+		{"news too old", urlset(newsEntry("Example News", "en", "Title", old)), []string{"news_article_too_old"}},
+		{"news in the wrong namespace", `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9" xmlns:news="http://example.com/news">` +
+			newsEntry("Example News", "en", "Title", recent) + `</urlset>`, []string{"news_invalid_namespace"}},
+		{"too many news articles", urlset(strings.Repeat(newsEntry("Example News", "en", "Title", recent), maxNewsURLs+1)), []string{"news_too_many_urls"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var parsed parsedURLSet
+			if err := xml.Unmarshal([]byte(tt.xml), &parsed); err != nil {
+				t.Fatalf("parsing: %v", err)
+			}
+			got := issueCodes(checkURLSet(&parsed, len(tt.xml), "https://example.com/sitemap.xml"))
+			want := append([]string{}, tt.want...)
+			sort.Strings(want)
+			if strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Errorf("issues = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestCheckSitemapIndex(t *testing.T) {
+	index := func(entries string) string {
+		return `<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">` + entries + `</sitemapindex>`
+	}
+
+	tests := []struct {
+		name       string
+		xml        string
+		sitemapURL string
+		want       []string
+	}{
+		{"valid", index(`<sitemap><loc>https://example.com/sitemaps/a/blog/blog-0001.xml</loc><lastmod>2024-05-01T10:00:00Z</lastmod></sitemap>`), "https://example.com/sitemaps/a.xml", nil},
+		{"empty", index(""), "https://example.com/sitemaps/a.xml", []string{"empty_file"}},
+		{"wrong namespace", `<sitemapindex xmlns="http://example.com/ns"><sitemap><loc>https://example.com/s.xml</loc></sitemap></sitemapindex>`, "https://example.com/a.xml", []string{"invalid_namespace"}},
+		{"invalid entries", index(`<sitemap><loc>s.xml</loc></sitemap><sitemap><loc>https://example.com/s.xml</loc><lastmod>soon</lastmod></sitemap><sitemap></sitemap>`),
+			"https://example.com/a.xml", []string{"invalid_lastmod", "invalid_loc", "missing_loc"}},
+		{"other host", index(`<sitemap><loc>https://cdn.example.org/s.xml</loc></sitemap>`), "https://example.com/a.xml", []string{"host_mismatch"}},
+		// Files read from storage have no host to compare with
+		{"read from storage", index(`<sitemap><loc>https://cdn.example.org/s.xml</loc></sitemap>`), "a.xml", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var parsed parsedSitemapIndex
+			if err := xml.Unmarshal([]byte(tt.xml), &parsed); err != nil {
+				t.Fatalf("parsing: %v", err)
+			}
+			got := issueCodes(checkSitemapIndex(&parsed, len(tt.xml), tt.sitemapURL))
+			want := append([]string{}, tt.want...)
+			sort.Strings(want)
+			if strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Errorf("issues = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestCheckTextSitemap(t *testing.T) {
+	locs := parseTextSitemap([]byte("https://example.com/a\n\n/relative\r\nhttps://other.example.org/b\n"))
+	got := issueCodes(checkTextSitemap(locs, 100, "https://example.com/sitemap.txt"))
+	want := []string{"host_mismatch", "invalid_loc"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("issues = %v, want %v", got, want)
+	}
+}
+
+func TestCheckLimits(t *testing.T) {
+	tests := []struct {
+		entries int
+		size    int
+		want    []string
+	}{
+		{1, 100, []string{}},
+		{maxSitemapURLs, maxSitemapBytes, []string{}},
+		{0, 100, []string{"empty_file"}},
+		{maxSitemapURLs + 1, 100, []string{"too_many_urls"}},
+		{1, maxSitemapBytes + 1, []string{"file_too_large"}},
+	}
+	for _, tt := range tests {
+		var issues issueList
+		checkLimits(&issues, tt.entries, tt.size)
+		if got := issueCodes(issues); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("checkLimits(%d, %d) = %v, want %v", tt.entries, tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestParseW3CDate(t *testing.T) {
+	tests := []struct {
+		value string
+		ok    bool
+	}{
+		{"2024", true},
+		{"2024-05", true},
+		{"2024-05-01", true},
+		{"2024-05-01T10:00Z", true},
+		{"2024-05-01T10:00+02:00", true},
+		{"2024-05-01T10:00:00Z", true},
+		{"2024-05-01T10:00:00.123+02:00", true},
+		{" 2024-05-01 ", true},
+		{"2024-05-01T10:00:00", false},
+		{"2024-13-01", false},
+		{"01/05/2024", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if _, ok := parseW3CDate(tt.value); ok != tt.ok {
+			t.Errorf("parseW3CDate(%q) ok = %v, want %v", tt.value, ok, tt.ok)
+		}
+	}
+}
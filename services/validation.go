@@ -0,0 +1,550 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sitemap-builder/models"
+	"sitemap-builder/storage"
+	"sitemap-builder/utils"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// validationsDir holds the CSV results of validation jobs from before results were stored in the database
+const validationsDir = "data/validations"
+
+// ValidationResultsFile returns the CSV file of a validation job from before
+// results were stored in the database
+func ValidationResultsFile(jobID string) string {
+	return filepath.Join(validationsDir, jobID+".csv")
+}
+
+// StartValidation creates a validation job for a sitemap index or sitemap URL
+// and runs it in the background
+func StartValidation(db *gorm.DB, target string, options models.ValidationOptions, userID uint) (*models.ValidationJob, error) {
+	job := &models.ValidationJob{
+		Target: target,
+		Source: "http",
+		UserID: userID,
+	}
+	return startValidationJob(db, job, options, nil)
+}
+
+// startValidationJob saves a new validation job and runs it in the background,
+// reading its files from store if one is given and over HTTP otherwise
+func startValidationJob(db *gorm.DB, job *models.ValidationJob, options models.ValidationOptions, store storage.Storage) (*models.ValidationJob, error) {
+	if err := NormalizeValidationOptions(&options); err != nil {
+		return nil, err
+	}
+	job.JobID = uuid.NewString()
+	job.State = "pending"
+	job.Options = options
+	if err := db.Create(job).Error; err != nil {
+		return nil, err
+	}
+
+	go runValidation(db, job, store)
+	return job, nil
+}
+
+// FailInterruptedValidations marks validation jobs that were pending or running
+// when the server stopped as failed, as nothing will resume them
+func FailInterruptedValidations(db *gorm.DB) (int64, error) {
+	result := db.Model(&models.ValidationJob{}).Where("state IN ?", []string{"pending", "running"}).Updates(map[string]interface{}{
+		"state":       "failed",
+		"error":       "interrupted by a server restart",
+		"finished_at": time.Now(),
+	})
+	return result.RowsAffected, result.Error
+}
+
+// validator runs a validation job, recording results and keeping the job's counts up to date
+type validator struct {
+	db      *gorm.DB
+	job     *models.ValidationJob
+	mu      sync.Mutex
+	http    *httpChecker
+	store   storage.Storage // set for jobs reading generated files from storage instead of over HTTP
+	pending []models.ValidationResult
+	issues  []models.ValidationIssue
+	visited map[string]bool
+	robots  map[string]*robotsHost
+}
+
+func runValidation(db *gorm.DB, job *models.ValidationJob, store storage.Storage) {
+	if store != nil {
+		defer store.Close()
+	}
+	checker, err := newHTTPChecker(job.Options, validationHeaderHosts(db, job))
+	if err != nil {
+		job.State = "failed"
+		job.Error = err.Error()
+		job.FinishedAt = time.Now()
+		db.Save(job)
+		return
+	}
+
+	v := &validator{db: db, job: job, http: checker, store: store}
+	job.State = "running"
+	job.StartedAt = time.Now()
+	job.Total = 1
+	db.Save(job)
+
+	// Counts are saved periodically so the summary can be followed while the job runs
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				v.save()
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	err = v.validateTarget(job.Target)
+	close(stop)
+	// A periodic save still in progress must not overwrite the final state
+	<-done
+
+	v.mu.Lock()
+	job.FinishedAt = time.Now()
+	job.State = "done"
+	if err != nil {
+		job.State = "failed"
+		job.Error = err.Error()
+	}
+	v.mu.Unlock()
+	v.save()
+	log.Printf("Validation %s of %s finished: %d OK, %d errors", job.JobID, job.Target, job.OKCount, job.ErrorCount)
+}
+
+// validationHeaderHosts returns the hosts a job sends its headers to: the host
+// of the target, or for jobs reading an index from storage the hosts of its
+// sitemaps' base URLs. Headers often carry credentials, so they are never sent
+// to the other hosts sitemaps and redirects may point to.
+func validationHeaderHosts(db *gorm.DB, job *models.ValidationJob) []string {
+	if job.Source != "storage" {
+		if u, err := url.Parse(job.Target); err == nil && u.Hostname() != "" {
+			return []string{u.Hostname()}
+		}
+		return nil
+	}
+
+	var baseURLs []string
+	db.Model(&models.SitemapConfig{}).
+		Joins("JOIN sitemaps ON sitemaps.id = sitemap_configs.sitemap_id AND sitemaps.deleted_at IS NULL").
+		Where("sitemaps.sitemap_index_id = ?", job.SitemapIndexID).
+		Pluck("sitemap_configs.base_url", &baseURLs)
+	var hosts []string
+	for _, baseURL := range baseURLs {
+		if u, err := url.Parse("https://" + baseURL); err == nil && u.Hostname() != "" {
+			hosts = append(hosts, u.Hostname())
+		}
+	}
+	return hosts
+}
+
+// save stores the results recorded since the last save and the job's current counts
+func (v *validator) save() {
+	v.mu.Lock()
+	if v.job.Total > 0 {
+		v.job.Progress = float64(v.job.Checked) * 100 / float64(v.job.Total)
+	}
+	job := *v.job
+	results, issues := v.pending, v.issues
+	v.pending, v.issues = nil, nil
+	v.mu.Unlock()
+
+	if len(results) > 0 {
+		if err := v.db.CreateInBatches(results, 500).Error; err != nil {
+			log.Printf("Error saving results of validation %s: %v", job.JobID, err)
+		}
+	}
+	if len(issues) > 0 {
+		if err := v.db.CreateInBatches(issues, 500).Error; err != nil {
+			log.Printf("Error saving issues of validation %s: %v", job.JobID, err)
+		}
+	}
+
+	v.db.Model(&models.ValidationJob{}).Where("id = ?", job.ID).Updates(map[string]interface{}{
+		"state":       job.State,
+		"total":       job.Total,
+		"checked":     job.Checked,
+		"ok_count":    job.OKCount,
+		"error_count": job.ErrorCount,
+		"issue_count": job.IssueCount,
+		"progress":    job.Progress,
+		"started_at":  job.StartedAt,
+		"finished_at": job.FinishedAt,
+		"error":       job.Error,
+	})
+}
+
+// addTotal adds discovered sitemaps or URLs to the number of checks to do
+func (v *validator) addTotal(n int) {
+	v.mu.Lock()
+	v.job.Total += n
+	v.mu.Unlock()
+}
+
+// record queues the result of one check for saving
+func (v *validator) record(result models.ValidationResult) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	result.JobID = v.job.ID
+	v.pending = append(v.pending, result)
+
+	v.job.Checked++
+	switch result.Status {
+	case "OK":
+		v.job.OKCount++
+	case "ERROR":
+		v.job.ErrorCount++
+	}
+}
+
+// recordIssues queues the protocol issues found in a sitemap index or sitemap for saving
+func (v *validator) recordIssues(sitemapURL string, issues []models.ValidationIssue) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for _, issue := range issues {
+		issue.JobID = v.job.ID
+		issue.SitemapURL = sitemapURL
+		v.issues = append(v.issues, issue)
+	}
+	v.job.IssueCount += len(issues)
+}
+
+// validateTarget validates the job's target, which is a sitemap index or a sitemap
+func (v *validator) validateTarget(sitemapURL string) error {
+	v.visit(sitemapURL)
+	v.checkRobotsSitemap(sitemapURL)
+	return v.validateFile(sitemapURL, nil)
+}
+
+// visit marks a sitemap index or sitemap as validated, returning false if it already was
+func (v *validator) visit(sitemapURL string) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.visited == nil {
+		v.visited = make(map[string]bool)
+	}
+	if v.visited[sitemapURL] {
+		return false
+	}
+	v.visited[sitemapURL] = true
+	return true
+}
+
+// validateFile validates a sitemap index or sitemap and, recursively, everything it
+// lists. parents holds the indexes leading to it, starting with the job's target.
+func (v *validator) validateFile(sitemapURL string, parents []string) error {
+	result := models.ValidationResult{URL: sitemapURL, Type: "sitemap", Depth: len(parents)}
+	if len(parents) > 0 {
+		result.Sitemap = parents[len(parents)-1]
+	} else {
+		result.Type = "index"
+	}
+
+	content, err := v.fetchSitemap(sitemapURL)
+	if err != nil {
+		if err == errSitemapTooLarge {
+			v.recordIssues(sitemapURL, []models.ValidationIssue{{Code: "file_too_large", Severity: "error", Message: err.Error()}})
+		}
+		result.Status, result.Error = "ERROR", err.Error()
+		v.record(result)
+		return err
+	}
+
+	if isTextSitemap(sitemapURL, content) {
+		locs := parseTextSitemap(content)
+		result.Type, result.Status, result.StatusCode = "sitemap", "OK", v.fileStatusCode()
+		v.record(result)
+		v.recordIssues(sitemapURL, checkTextSitemap(locs, len(content), sitemapURL))
+
+		urls := make([]parsedURL, len(locs))
+		for i, loc := range locs {
+			urls[i].Loc = loc
+		}
+		v.checkURLs(urls, sitemapURL, len(parents)+1)
+		return nil
+	}
+
+	var index parsedSitemapIndex
+	if err := xml.Unmarshal(content, &index); err == nil {
+		result.Type, result.Status, result.StatusCode = "index", "OK", v.fileStatusCode()
+		v.record(result)
+		issues := checkSitemapIndex(&index, len(content), sitemapURL)
+		if len(parents) > 0 {
+			issues = append(issues, models.ValidationIssue{Code: "nested_index", Severity: "warning",
+				Message: fmt.Sprintf("sitemap index is listed in the sitemap index %s, search engines do not support nested indexes", result.Sitemap)})
+		}
+		v.recordIssues(sitemapURL, issues)
+		v.validateChildren(index.Sitemaps, append(parents[:len(parents):len(parents)], sitemapURL))
+		return nil
+	}
+
+	var urlset parsedURLSet
+	if err := xml.Unmarshal(content, &urlset); err == nil {
+		// Locs are trimmed like the sitemap locs of an index, so padded ones are checked as the URL they hold
+		for i := range urlset.URLs {
+			urlset.URLs[i].Loc = strings.TrimSpace(urlset.URLs[i].Loc)
+		}
+		result.Type, result.Status, result.StatusCode = "sitemap", "OK", v.fileStatusCode()
+		v.record(result)
+		v.recordIssues(sitemapURL, checkURLSet(&urlset, len(content), sitemapURL))
+		v.checkURLs(urlset.URLs, sitemapURL, len(parents)+1)
+		return nil
+	}
+
+	err = fmt.Errorf("Invalid XML format: not a valid sitemap index or URL set")
+	result.Status, result.Error = "ERROR", err.Error()
+	v.record(result)
+	return err
+}
+
+// fileStatusCode is the status code of a sitemap file that was read, none for files read from storage
+func (v *validator) fileStatusCode() int {
+	if v.store != nil {
+		return 0
+	}
+	return http.StatusOK
+}
+
+// validateChildren validates the sitemaps listed in an index with limited concurrency,
+// skipping sitemaps that were already validated
+func (v *validator) validateChildren(sitemaps []parsedSitemap, parents []string) {
+	indexURL := parents[len(parents)-1]
+
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, 5) // Limit to 5 concurrent requests
+
+	for _, sitemap := range sitemaps {
+		sitemapURL := strings.TrimSpace(sitemap.Loc)
+		if sitemapURL == "" {
+			continue
+		}
+		if len(parents) > maxIndexDepth {
+			v.recordIssues(indexURL, []models.ValidationIssue{{URL: sitemapURL, Code: "max_depth_exceeded", Severity: "error",
+				Message: fmt.Sprintf("sitemap is nested more than %d indexes deep and was not validated", maxIndexDepth)}})
+			continue
+		}
+		if !v.visit(sitemapURL) {
+			v.recordIssues(indexURL, []models.ValidationIssue{revisitIssue(sitemapURL, parents)})
+			continue
+		}
+		v.addTotal(1)
+
+		wg.Add(1)
+		semaphore <- struct{}{}
+
+		go func(sitemapURL string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			v.validateFile(sitemapURL, parents)
+		}(sitemapURL)
+	}
+
+	wg.Wait()
+}
+
+// revisitIssue reports a sitemap listed again: a cycle if it is one of the indexes
+// leading to it, otherwise a duplicate
+func revisitIssue(sitemapURL string, parents []string) models.ValidationIssue {
+	for _, parent := range parents {
+		if parent == sitemapURL {
+			return models.ValidationIssue{URL: sitemapURL, Code: "index_cycle", Severity: "error",
+				Message: fmt.Sprintf("sitemap index lists %s, which leads back to it", sitemapURL)}
+		}
+	}
+	return models.ValidationIssue{URL: sitemapURL, Code: "duplicate_sitemap", Severity: "warning",
+		Message: "sitemap is listed more than once and was validated only once"}
+}
+
+// checkURLs checks that the URLs of a sitemap respond, with limited concurrency
+func (v *validator) checkURLs(urls []parsedURL, sitemapURL string, depth int) {
+	v.addTotal(len(urls))
+
+	var wg sync.WaitGroup
+	// Requests are limited job-wide by the checker, this only bounds the goroutines waiting for it
+	urlSemaphore := make(chan struct{}, v.job.Options.Concurrency)
+
+	for _, url := range urls {
+		if v.job.Options.SkipURLChecks {
+			v.record(models.ValidationResult{URL: url.Loc, Type: "url", Sitemap: sitemapURL, Depth: depth, Status: "SKIPPED"})
+			continue
+		}
+
+		wg.Add(1)
+		urlSemaphore <- struct{}{}
+
+		go func(loc string) {
+			defer wg.Done()
+			defer func() { <-urlSemaphore }()
+
+			check := v.http.check(loc)
+			result := models.ValidationResult{
+				URL:        loc,
+				Type:       "url",
+				Sitemap:    sitemapURL,
+				Depth:      depth,
+				Status:     check.status,
+				StatusCode: check.statusCode,
+				FinalURL:   check.finalURL,
+				Redirects:  check.redirects,
+			}
+			if check.err != nil {
+				result.Error = check.err.Error()
+			}
+			issues := urlCheckIssues(loc, check)
+			if !v.robotsAllowed(loc) {
+				result.BlockedByRobots = true
+				issues = append(issues, models.ValidationIssue{URL: loc, Code: "blocked_by_robots", Severity: "error",
+					Message: fmt.Sprintf("robots.txt disallows the URL for %s", v.job.Options.RobotsUserAgent)})
+			}
+			if page := check.page; page != nil {
+				result.Noindex = page.noindex
+				result.XRobotsTag = page.xRobotsTag
+				result.MetaRobots = page.metaRobots
+				result.Canonical = page.canonical
+				result.CanonicalMismatch = page.canonicalMismatch
+				result.BodySize = page.bodySize
+				result.SoftNotFound = page.softNotFound != ""
+				issues = append(issues, pageCheckIssues(loc, page)...)
+			}
+			v.record(result)
+			v.recordIssues(sitemapURL, issues)
+		}(url.Loc)
+	}
+
+	wg.Wait()
+}
+
+// urlCheckIssues reports the redirects and non-200 responses of a URL listed in a sitemap,
+// since search engines expect sitemaps to list the final, canonical URLs
+func urlCheckIssues(loc string, check urlCheck) []models.ValidationIssue {
+	var issues issueList
+	switch {
+	case check.loop:
+		issues.add("error", "redirect_loop", loc, "URL redirects in a loop after %d hops", len(check.redirects))
+	case check.tooMany:
+		issues.add("error", "too_many_redirects", loc, "URL redirects more than %d times", maxRedirects)
+	case len(check.redirects) > 0:
+		issues.add("warning", "redirected", loc, "URL redirects to %s in %d hops, list the final URL instead", check.finalURL, len(check.redirects))
+	}
+
+	// Failed requests without a response are reported as results only
+	if check.statusCode != 0 && check.statusCode != http.StatusOK && !check.loop && !check.tooMany {
+		severity := "error"
+		if check.statusCode < 300 {
+			severity = "warning"
+		}
+		issues.add(severity, "non_200_status", loc, "URL answers with status code %d", check.statusCode)
+	}
+	return issues
+}
+
+// errSitemapTooLarge is returned for sitemaps larger than the protocol allows once decompressed
+var errSitemapTooLarge = fmt.Errorf("sitemap is larger than %d bytes uncompressed", maxSitemapBytes)
+
+// fetchSitemap fetches a sitemap index or sitemap, decompressing gzipped files
+// (detected by their content, as servers often send them without Content-Encoding)
+func (v *validator) fetchSitemap(url string) ([]byte, error) {
+	if v.store != nil {
+		return readStoredSitemap(v.store, strings.TrimSuffix(v.job.Target, ".xml"), url)
+	}
+
+	content, err := v.http.fetch(url)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(content) >= 2 && content[0] == 0x1f && content[1] == 0x8b {
+		gz, err := gzip.NewReader(bytes.NewReader(content))
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip content: %v", err)
+		}
+		defer gz.Close()
+
+		// Read one byte past the limit to detect oversized files without decompressing them fully
+		content, err = ioutil.ReadAll(io.LimitReader(gz, maxSitemapBytes+1))
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip content: %v", err)
+		}
+	}
+
+	if len(content) > maxSitemapBytes {
+		return nil, errSitemapTooLarge
+	}
+	return content, nil
+}
+
+// utf8BOM is the byte order mark some editors put at the start of text files
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// textSniffLines is how many lines of a file without a .txt extension are checked
+// to look like URLs before it is read as a text sitemap
+const textSniffLines = 5
+
+// isTextSitemap reports whether a sitemap is a text file listing one URL per line.
+// Files without a .txt extension are only read as text if they do not start with
+// markup and their first lines are URLs, so that error pages, JSON and the like
+// are reported as invalid rather than as a sitemap of broken URLs.
+func isTextSitemap(url string, content []byte) bool {
+	path := strings.TrimSuffix(strings.SplitN(url, "?", 2)[0], ".gz")
+	if strings.HasSuffix(path, ".txt") {
+		return true
+	}
+
+	content = bytes.TrimSpace(bytes.TrimPrefix(content, utf8BOM))
+	if len(content) == 0 || bytes.HasPrefix(content, []byte("<")) {
+		return false
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for checked := 0; checked < textSniffLines && scanner.Scan(); {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if !utils.IsAbsoluteHTTPURL(line) {
+			return false
+		}
+		checked++
+	}
+	return scanner.Err() == nil
+}
+
+// parseTextSitemap returns the URLs of a text sitemap, skipping blank lines
+func parseTextSitemap(content []byte) []string {
+	var locs []string
+	content = bytes.TrimPrefix(content, utf8BOM)
+	for _, line := range strings.Split(string(content), "\n") {
+		if loc := strings.TrimSpace(line); loc != "" {
+			locs = append(locs, loc)
+		}
+	}
+	return locs
+}
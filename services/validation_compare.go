@@ -0,0 +1,160 @@
+package services
+
+import (
+	"sitemap-builder/models"
+	"sort"
+
+	"gorm.io/gorm"
+)
+
+// ComparedResult is an entry whose outcome differs between two validation jobs
+type ComparedResult struct {
+	URL            string `json:"url"`
+	Type           string `json:"type"`
+	Sitemap        string `json:"sitemap"`
+	BaseStatus     string `json:"base_status,omitempty"`
+	HeadStatus     string `json:"head_status,omitempty"`
+	BaseStatusCode int    `json:"base_status_code,omitempty"`
+	HeadStatusCode int    `json:"head_status_code,omitempty"`
+	Error          string `json:"error,omitempty"` // of the head job
+}
+
+// ValidationComparison lists what changed from a base validation job to a later head job
+type ValidationComparison struct {
+	Counts        map[string]int   `json:"counts"`
+	NewlyFailing  []ComparedResult `json:"newly_failing"`
+	Recovered     []ComparedResult `json:"recovered"`
+	StatusChanges []ComparedResult `json:"status_changes"`
+	Added         []ComparedResult `json:"added"`
+	Removed       []ComparedResult `json:"removed"`
+}
+
+// CompareValidationJobs compares the results of two jobs by entry type and URL,
+// returning at most limit entries per list (all lists are counted in full)
+func CompareValidationJobs(db *gorm.DB, base, head *models.ValidationJob, limit int) (*ValidationComparison, error) {
+	baseResults, err := comparableResults(db, base.ID)
+	if err != nil {
+		return nil, err
+	}
+	headResults, err := comparableResults(db, head.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	comparison := &ValidationComparison{}
+	for key, h := range headResults {
+		b, ok := baseResults[key]
+		entry := ComparedResult{URL: h.URL, Type: h.Type, Sitemap: h.Sitemap, HeadStatus: h.Status, HeadStatusCode: h.StatusCode, Error: h.Error}
+		if !ok {
+			comparison.Added = append(comparison.Added, entry)
+			continue
+		}
+		entry.BaseStatus, entry.BaseStatusCode = b.Status, b.StatusCode
+
+		switch {
+		case b.Status != "ERROR" && h.Status == "ERROR":
+			comparison.NewlyFailing = append(comparison.NewlyFailing, entry)
+		case b.Status == "ERROR" && h.Status == "OK":
+			comparison.Recovered = append(comparison.Recovered, entry)
+		}
+		// Skipped checks, failed requests and files read from storage have no status code to compare
+		if b.StatusCode != h.StatusCode && b.StatusCode != 0 && h.StatusCode != 0 {
+			comparison.StatusChanges = append(comparison.StatusChanges, entry)
+		}
+	}
+	for key, b := range baseResults {
+		if _, ok := headResults[key]; !ok {
+			comparison.Removed = append(comparison.Removed, ComparedResult{
+				URL: b.URL, Type: b.Type, Sitemap: b.Sitemap, BaseStatus: b.Status, BaseStatusCode: b.StatusCode,
+			})
+		}
+	}
+
+	comparison.Counts = map[string]int{
+		"newly_failing":  len(comparison.NewlyFailing),
+		"recovered":      len(comparison.Recovered),
+		"status_changes": len(comparison.StatusChanges),
+		"added":          len(comparison.Added),
+		"removed":        len(comparison.Removed),
+	}
+	for _, list := range []*[]ComparedResult{&comparison.NewlyFailing, &comparison.Recovered,
+		&comparison.StatusChanges, &comparison.Added, &comparison.Removed} {
+		*list = sortedComparedResults(*list, limit)
+	}
+	return comparison, nil
+}
+
+// comparableResults loads the results of a job keyed by entry type and URL
+func comparableResults(db *gorm.DB, jobID uint) (map[string]models.ValidationResult, error) {
+	var results []models.ValidationResult
+	err := db.Select("url", "type", "sitemap", "depth", "status", "status_code", "error").
+		Where("job_id = ?", jobID).
+		Find(&results).Error
+	if err != nil {
+		return nil, err
+	}
+
+	byKey := make(map[string]models.ValidationResult, len(results))
+	for _, result := range results {
+		// The job's target may be read from storage in one job and over HTTP in
+		// the other, so it is always compared with the other target
+		key := result.Type + " " + result.URL
+		if result.Depth == 0 && result.Sitemap == "" {
+			key = "target"
+		}
+		byKey[key] = result
+	}
+	return byKey, nil
+}
+
+// sortedComparedResults sorts entries by URL and keeps at most limit of them
+func sortedComparedResults(results []ComparedResult, limit int) []ComparedResult {
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].URL != results[j].URL {
+			return results[i].URL < results[j].URL
+		}
+		return results[i].Type < results[j].Type
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	if results == nil {
+		results = []ComparedResult{}
+	}
+	return results
+}
+
+// baselineScope restricts a query to the jobs sharing a job's baseline: those of
+// the same sitemap index, or for jobs validating a URL, of the same target
+func baselineScope(db *gorm.DB, job *models.ValidationJob) *gorm.DB {
+	query := db.Model(&models.ValidationJob{})
+	if job.SitemapIndexID != 0 {
+		return query.Where("sitemap_index_id = ?", job.SitemapIndexID)
+	}
+	return query.Where("(sitemap_index_id = 0 OR sitemap_index_id IS NULL) AND target = ?", job.Target)
+}
+
+// SetValidationBaseline marks a finished job as the baseline of its sitemap
+// index or target, replacing the previous baseline
+func SetValidationBaseline(db *gorm.DB, job *models.ValidationJob) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := baselineScope(tx, job).Where("baseline = ?", true).Update("baseline", false).Error; err != nil {
+			return err
+		}
+		job.Baseline = true
+		return tx.Model(job).Update("baseline", true).Error
+	})
+}
+
+// ValidationBaseline returns the baseline job for a job's sitemap index or
+// target, or nil if there is none
+func ValidationBaseline(db *gorm.DB, job *models.ValidationJob) (*models.ValidationJob, error) {
+	var baselines []models.ValidationJob
+	if err := baselineScope(db, job).Where("baseline = ?", true).Limit(1).Find(&baselines).Error; err != nil {
+		return nil, err
+	}
+	if len(baselines) == 0 {
+		return nil, nil
+	}
+	return &baselines[0], nil
+}
@@ -0,0 +1,131 @@
+package services
+
+import (
+	"reflect"
+	"sitemap-builder/models"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newCompareDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.ValidationJob{}, &models.ValidationResult{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// createJob saves a validation job with the given results
+func createJob(t *testing.T, db *gorm.DB, job models.ValidationJob, results ...models.ValidationResult) *models.ValidationJob {
+	t.Helper()
+	if err := db.Create(&job).Error; err != nil {
+		t.Fatal(err)
+	}
+	for i := range results {
+		results[i].JobID = job.ID
+	}
+	if len(results) > 0 {
+		if err := db.Create(&results).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &job
+}
+
+func TestCompareValidationJobs(t *testing.T) {
+	db := newCompareDB(t)
+	const sitemap = "https://example.com/sitemap.xml"
+	url := func(loc, status string, statusCode int) models.ValidationResult {
+		return models.ValidationResult{URL: "https://example.com" + loc, Type: "url", Sitemap: sitemap, Depth: 1, Status: status, StatusCode: statusCode}
+	}
+
+	// The base job read the target from storage, the head job over HTTP
+	base := createJob(t, db, models.ValidationJob{JobID: "base", Target: "storage:a"},
+		models.ValidationResult{URL: "a.xml", Type: "index", Status: "OK"},
+		url("/same", "OK", 200),
+		url("/breaks", "OK", 200),
+		url("/recovers", "ERROR", 500),
+		url("/moves", "ERROR", 404),
+		url("/skipped", "SKIPPED", 0),
+		url("/removed", "OK", 200),
+	)
+	head := createJob(t, db, models.ValidationJob{JobID: "head", Target: sitemap},
+		models.ValidationResult{URL: sitemap, Type: "index", Status: "OK", StatusCode: 200},
+		url("/same", "OK", 200),
+		url("/breaks", "ERROR", 503),
+		url("/recovers", "OK", 200),
+		url("/moves", "ERROR", 410),
+		url("/skipped", "OK", 200),
+		url("/added", "OK", 200),
+	)
+
+	comparison, err := CompareValidationJobs(db, base, head, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	urls := func(results []ComparedResult) []string {
+		var locs []string
+		for _, result := range results {
+			locs = append(locs, result.URL)
+		}
+		return locs
+	}
+	for name, tt := range map[string]struct {
+		got, want []string
+	}{
+		"newly failing":  {urls(comparison.NewlyFailing), []string{"https://example.com/breaks"}},
+		"recovered":      {urls(comparison.Recovered), []string{"https://example.com/recovers"}},
+		"status changes": {urls(comparison.StatusChanges), []string{"https://example.com/breaks", "https://example.com/moves", "https://example.com/recovers"}},
+		"added":          {urls(comparison.Added), []string{"https://example.com/added"}},
+		"removed":        {urls(comparison.Removed), []string{"https://example.com/removed"}},
+	} {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("%s: %q, want %q", name, tt.got, tt.want)
+		}
+	}
+
+	want := ComparedResult{URL: "https://example.com/breaks", Type: "url", Sitemap: sitemap,
+		BaseStatus: "OK", HeadStatus: "ERROR", BaseStatusCode: 200, HeadStatusCode: 503}
+	if comparison.NewlyFailing[0] != want {
+		t.Errorf("newly failing entry %+v, want %+v", comparison.NewlyFailing[0], want)
+	}
+
+	// Lists are cut at the limit, counts are not
+	limited, err := CompareValidationJobs(db, base, head, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(limited.StatusChanges) != 1 || limited.Counts["status_changes"] != 3 {
+		t.Errorf("limit 1: %d status changes listed, %d counted, want 1 and 3", len(limited.StatusChanges), limited.Counts["status_changes"])
+	}
+}
+
+func TestValidationBaseline(t *testing.T) {
+	db := newCompareDB(t)
+	first := createJob(t, db, models.ValidationJob{JobID: "first", Target: "https://example.com/sitemap.xml"})
+	second := createJob(t, db, models.ValidationJob{JobID: "second", Target: "https://example.com/sitemap.xml"})
+	other := createJob(t, db, models.ValidationJob{JobID: "other", Target: "https://example.org/sitemap.xml"})
+
+	if baseline, err := ValidationBaseline(db, second); err != nil || baseline != nil {
+		t.Fatalf("baseline before one was set = %v, %v", baseline, err)
+	}
+	for _, job := range []*models.ValidationJob{first, other, second} {
+		if err := SetValidationBaseline(db, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Setting the second job replaced the first as baseline of the target only
+	if baseline, err := ValidationBaseline(db, first); err != nil || baseline == nil || baseline.JobID != "second" {
+		t.Errorf("baseline of the target = %+v, %v, want the second job", baseline, err)
+	}
+	if baseline, err := ValidationBaseline(db, other); err != nil || baseline == nil || baseline.JobID != "other" {
+		t.Errorf("baseline of the other target = %+v, %v, want its own job", baseline, err)
+	}
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sitemap-builder/models"
+	"sitemap-builder/utils"
+	"strings"
+)
+
+const (
+	defaultSoftNotFoundMinBytes = 512
+	// maxPageBytes bounds how much of a page deep validation reads
+	maxPageBytes = 5 * 1024 * 1024
+)
+
+// pageCheck is what deep validation found on the page a URL answers with
+type pageCheck struct {
+	noindex           bool
+	xRobotsTag        string
+	metaRobots        string
+	canonical         string
+	canonicalMismatch bool
+	bodySize          int
+	softNotFound      string // why the page looks like an error page, empty if it does not
+}
+
+// inspectPage reads the page a URL answered with and checks that it is an
+// indexable page whose canonical URL is the sitemap's loc
+func inspectPage(resp *http.Response, loc string, options models.ValidationOptions) (*pageCheck, error) {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxPageBytes))
+	if err != nil {
+		return nil, fmt.Errorf("reading page: %v", err)
+	}
+
+	page := &pageCheck{
+		xRobotsTag: strings.Join(resp.Header.Values("X-Robots-Tag"), ", "),
+		bodySize:   len(body),
+	}
+	if robotsNoindex(page.xRobotsTag) {
+		page.noindex = true
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	isHTML := contentType == "" || strings.Contains(contentType, "html")
+	if isHTML {
+		html, err := utils.ParseHTML(bytes.NewReader(body))
+		if err == nil {
+			page.metaRobots = html.MetaRobots
+			if robotsNoindex(html.MetaRobots) {
+				page.noindex = true
+			}
+			if html.Canonical != "" {
+				page.canonical = html.Canonical
+				if canonical, err := resolveLocation(resp.Request.URL.String(), html.Canonical); err == nil {
+					page.canonical = canonical
+				}
+				page.canonicalMismatch = !sameURL(page.canonical, loc)
+			}
+		}
+	}
+
+	if isHTML && len(body) < options.SoftNotFoundMinBytes {
+		page.softNotFound = fmt.Sprintf("page is only %d bytes", len(body))
+	}
+	lowerBody := strings.ToLower(string(body))
+	for _, marker := range options.SoftNotFoundMarkers {
+		if marker != "" && strings.Contains(lowerBody, strings.ToLower(marker)) {
+			page.softNotFound = fmt.Sprintf("page contains the error page marker %q", marker)
+			break
+		}
+	}
+	return page, nil
+}
+
+// robotsNoindex reports whether a robots meta tag or X-Robots-Tag value forbids
+// indexing. Directives may be prefixed with a user agent ("googlebot: noindex").
+func robotsNoindex(value string) bool {
+	for _, directive := range strings.FieldsFunc(strings.ToLower(value), func(r rune) bool {
+		return r == ',' || r == ':' || r == ' '
+	}) {
+		if directive == "noindex" || directive == "none" {
+			return true
+		}
+	}
+	return false
+}
+
+// sameURL reports whether two URLs are equal, ignoring the case of their
+// scheme and host, default ports and fragments
+func sameURL(a, b string) bool {
+	ua, errA := url.Parse(a)
+	ub, errB := url.Parse(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return normalizedURL(ua) == normalizedURL(ub)
+}
+
+func normalizedURL(u *url.URL) string {
+	scheme := strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Host)
+	if (scheme == "http" && strings.HasSuffix(host, ":80")) || (scheme == "https" && strings.HasSuffix(host, ":443")) {
+		host = host[:strings.LastIndex(host, ":")]
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	normalized := scheme + "://" + host + path
+	if u.RawQuery != "" {
+		normalized += "?" + u.RawQuery
+	}
+	return normalized
+}
+
+// pageCheckIssues reports pages that should not be listed in a sitemap
+func pageCheckIssues(loc string, page *pageCheck) []models.ValidationIssue {
+	var issues issueList
+	if page.noindex {
+		issues.add("error", "noindex", loc, "page is marked noindex (robots meta tag %q, X-Robots-Tag %q)", page.metaRobots, page.xRobotsTag)
+	}
+	if page.canonicalMismatch {
+		issues.add("warning", "canonical_mismatch", loc, "page declares %s as its canonical URL", page.canonical)
+	}
+	if page.softNotFound != "" {
+		issues.add("error", "soft_404", loc, "page looks like an error page: %s", page.softNotFound)
+	}
+	return issues
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sitemap-builder/models"
+	"strings"
+	"testing"
+)
+
+func TestInspectPage(t *testing.T) {
+	content := strings.Repeat("<p>Some article text.</p>", 40)
+	page := func(head, body string) string {
+		return "<html><head>" + head + "</head><body>" + body + "</body></html>"
+	}
+
+	tests := []struct {
+		name          string
+		contentType   string
+		xRobotsTag    string
+		body          string
+		wantNoindex   bool
+		wantCanonical string // relative to the server
+		wantMismatch  bool
+		wantSoft404   bool
+	}{
+		{name: "indexable", body: page(`<link rel="canonical" href="/page">`, content), wantCanonical: "/page"},
+		{name: "meta noindex", body: page(`<meta name="robots" content="noindex, follow">`, content), wantNoindex: true},
+		{name: "X-Robots-Tag", xRobotsTag: "googlebot: noindex", body: page("", content), wantNoindex: true},
+		{name: "canonical elsewhere", body: page(`<link rel="canonical" href="/other">`, content), wantCanonical: "/other", wantMismatch: true},
+		{name: "small page", body: page("", "Oops"), wantSoft404: true},
+		{name: "error page marker", body: page("", "<h1>Page Not Found</h1>"+content), wantSoft404: true},
+		{name: "small file that is not HTML", contentType: "application/pdf", body: "%PDF-1.4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.contentType != "" {
+					w.Header().Set("Content-Type", tt.contentType)
+				}
+				if tt.xRobotsTag != "" {
+					w.Header().Set("X-Robots-Tag", tt.xRobotsTag)
+				}
+				fmt.Fprint(w, tt.body)
+			}))
+			defer server.Close()
+
+			resp, err := http.Get(server.URL + "/page")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			options := models.ValidationOptions{SoftNotFoundMinBytes: defaultSoftNotFoundMinBytes, SoftNotFoundMarkers: []string{"page not found"}}
+			page, err := inspectPage(resp, server.URL+"/page", options)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if page.noindex != tt.wantNoindex {
+				t.Errorf("noindex = %v, want %v", page.noindex, tt.wantNoindex)
+			}
+			if wantCanonical := tt.wantCanonical; wantCanonical != "" {
+				if page.canonical != server.URL+wantCanonical {
+					t.Errorf("canonical = %q, want %q", page.canonical, server.URL+wantCanonical)
+				}
+			} else if page.canonical != "" {
+				t.Errorf("canonical = %q, want none", page.canonical)
+			}
+			if page.canonicalMismatch != tt.wantMismatch {
+				t.Errorf("canonical mismatch = %v, want %v", page.canonicalMismatch, tt.wantMismatch)
+			}
+			if (page.softNotFound != "") != tt.wantSoft404 {
+				t.Errorf("soft 404 = %q, want %v", page.softNotFound, tt.wantSoft404)
+			}
+			if page.bodySize != len(tt.body) {
+				t.Errorf("body size = %d, want %d", page.bodySize, len(tt.body))
+			}
+		})
+	}
+}
+
+func TestRobotsNoindex(t *testing.T) {
+	tests := map[string]bool{
+		"":                        false,
+		"index, follow":           false,
+		"noindex":                 true,
+		"NOINDEX,nofollow":        true,
+		"none":                    true,
+		"googlebot: noindex":      true,
+		"noimageindex, noarchive": false,
+	}
+	for value, want := range tests {
+		if got := robotsNoindex(value); got != want {
+			t.Errorf("robotsNoindex(%q) = %v, want %v", value, got, want)
+		}
+	}
+}
+
+func TestSameURL(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"https://example.com/a", "https://example.com/a", true},
+		{"HTTPS://Example.com/a", "https://example.com/a", true},
+		{"https://example.com:443/a", "https://example.com/a", true},
+		{"http://example.com:80", "http://example.com/", true},
+		{"https://example.com/a#top", "https://example.com/a", true},
+		{"https://example.com/a", "https://example.com/A", false},
+		{"https://example.com/a?x=1", "https://example.com/a", false},
+		{"http://example.com/a", "https://example.com/a", false},
+	}
+	for _, tt := range tests {
+		if got := sameURL(tt.a, tt.b); got != tt.want {
+			t.Errorf("sameURL(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
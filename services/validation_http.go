@@ -0,0 +1,386 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sitemap-builder/models"
+	"sitemap-builder/secrets"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of the validation options
+const (
+	defaultValidationTimeout     = 5
+	defaultValidationBackoff     = 500
+	defaultValidationConcurrency = 10
+	defaultValidationUserAgent   = "SitemapBuilderBot/1.0"
+	maxValidationConcurrency     = 100
+	maxValidationRetries         = 10
+	maxRetryAfter                = time.Minute
+	maxRedirects                 = 10
+)
+
+// NormalizeValidationOptions checks validation options and fills in the defaults of unset ones
+func NormalizeValidationOptions(options *models.ValidationOptions) error {
+	options.Method = strings.ToUpper(options.Method)
+	switch options.Method {
+	case "":
+		options.Method = http.MethodHead
+	case http.MethodHead, http.MethodGet:
+	default:
+		return fmt.Errorf("method must be HEAD or GET")
+	}
+
+	if options.TimeoutSeconds < 0 || options.Retries < 0 || options.RetryBackoffMS < 0 ||
+		options.RequestsPerSecond < 0 || options.Concurrency < 0 {
+		return fmt.Errorf("options must not be negative")
+	}
+	if options.Retries > maxValidationRetries {
+		return fmt.Errorf("retries must be at most %d", maxValidationRetries)
+	}
+	if options.SoftNotFoundMinBytes < 0 {
+		return fmt.Errorf("soft_404_min_bytes must not be negative")
+	}
+	if options.Concurrency > maxValidationConcurrency {
+		return fmt.Errorf("concurrency must be at most %d", maxValidationConcurrency)
+	}
+
+	if options.TimeoutSeconds == 0 {
+		options.TimeoutSeconds = defaultValidationTimeout
+	}
+	if options.RetryBackoffMS == 0 {
+		options.RetryBackoffMS = defaultValidationBackoff
+	}
+	if options.Concurrency == 0 {
+		options.Concurrency = defaultValidationConcurrency
+	}
+	if options.UserAgent == "" {
+		options.UserAgent = defaultValidationUserAgent
+	}
+	if options.RobotsUserAgent == "" {
+		options.RobotsUserAgent = defaultRobotsUserAgent
+	}
+	for name, value := range options.Headers {
+		if _, err := secrets.Resolve(value); err != nil {
+			return fmt.Errorf("header %s: %v", name, err)
+		}
+	}
+	if options.Deep && options.SoftNotFoundMinBytes == 0 {
+		options.SoftNotFoundMinBytes = defaultSoftNotFoundMinBytes
+	}
+	return nil
+}
+
+// httpChecker sends the requests of a validation job, applying its options: the
+// user agent and headers, the per-host rate, the job-wide concurrency and retries
+type httpChecker struct {
+	options     models.ValidationOptions
+	client      *http.Client
+	fetchClient *http.Client // for sitemaps, with a timeout of at least 30 seconds
+	slots       chan struct{}
+	// The job's headers, with secret references resolved, are only sent to headerHosts
+	headers     map[string]string
+	headerHosts map[string]bool
+
+	mu        sync.Mutex
+	nextFetch map[string]time.Time
+}
+
+// newHTTPChecker returns the checker of a job whose headers are sent to the given hosts only
+func newHTTPChecker(options models.ValidationOptions, headerHosts []string) (*httpChecker, error) {
+	headers := make(map[string]string, len(options.Headers))
+	for name, value := range options.Headers {
+		resolved, err := secrets.Resolve(value)
+		if err != nil {
+			return nil, fmt.Errorf("header %s: %v", name, err)
+		}
+		headers[name] = resolved
+	}
+	hosts := make(map[string]bool, len(headerHosts))
+	for _, host := range headerHosts {
+		hosts[strings.ToLower(host)] = true
+	}
+
+	timeout := time.Duration(options.TimeoutSeconds) * time.Second
+	fetchTimeout := 30 * time.Second
+	if timeout > fetchTimeout {
+		fetchTimeout = timeout
+	}
+	c := &httpChecker{
+		options:     options,
+		headers:     headers,
+		headerHosts: hosts,
+		// URL checks follow redirects themselves, to record each hop
+		client: &http.Client{
+			Timeout: timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		slots:     make(chan struct{}, options.Concurrency),
+		nextFetch: make(map[string]time.Time),
+	}
+	c.fetchClient = &http.Client{
+		Timeout: fetchTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("too many redirects")
+			}
+			// Redirected requests carry the previous request's headers
+			if !c.sendsHeaders(req.URL) {
+				for name := range c.headers {
+					req.Header.Del(name)
+				}
+			}
+			return nil
+		},
+	}
+	return c, nil
+}
+
+// sendsHeaders reports whether the job's headers are sent to the URL's host
+func (c *httpChecker) sendsHeaders(u *url.URL) bool {
+	return c.headerHosts[strings.ToLower(u.Hostname())]
+}
+
+// urlCheck is the outcome of checking a URL, following its redirects
+type urlCheck struct {
+	status     string
+	statusCode int
+	err        error
+	finalURL   string
+	redirects  []models.RedirectHop
+	loop       bool
+	tooMany    bool
+	page       *pageCheck // set by deep validation
+}
+
+// check requests a URL with the job's method, following redirects one hop at a
+// time so that each hop is recorded and loops are detected. Deep validation GETs
+// the URL and inspects the page it finally answers with.
+func (c *httpChecker) check(loc string) urlCheck {
+	check := urlCheck{status: "ERROR", finalURL: loc}
+	seen := map[string]bool{loc: true}
+	method := c.options.Method
+	if c.options.Deep {
+		method = http.MethodGet
+	}
+
+	for {
+		resp, err := c.request(method, check.finalURL)
+		if err != nil {
+			check.err = err
+			return check
+		}
+		check.statusCode = resp.StatusCode
+
+		location := resp.Header.Get("Location")
+		if !isRedirect(resp.StatusCode) || location == "" {
+			if c.options.Deep && resp.StatusCode >= 200 && resp.StatusCode < 300 {
+				check.page, err = inspectPage(resp, loc, c.options)
+			}
+			resp.Body.Close()
+			if err != nil {
+				check.err = err
+				return check
+			}
+			break
+		}
+		resp.Body.Close()
+		check.redirects = append(check.redirects, models.RedirectHop{URL: check.finalURL, StatusCode: resp.StatusCode, Location: location})
+
+		next, err := resolveLocation(check.finalURL, location)
+		if err != nil {
+			check.err = fmt.Errorf("invalid redirect location %q", location)
+			return check
+		}
+		if seen[next] {
+			check.loop = true
+			check.err = fmt.Errorf("redirect loop back to %s", next)
+			return check
+		}
+		if len(check.redirects) >= maxRedirects {
+			check.tooMany = true
+			check.err = fmt.Errorf("more than %d redirects", maxRedirects)
+			return check
+		}
+		seen[next] = true
+		check.finalURL = next
+	}
+
+	if check.statusCode >= 200 && check.statusCode < 300 {
+		check.status = "OK"
+		return check
+	}
+	check.err = fmt.Errorf("status code %d", check.statusCode)
+	return check
+}
+
+// request sends one request, falling back from HEAD to GET for servers that do not support HEAD
+func (c *httpChecker) request(method, rawURL string) (*http.Response, error) {
+	resp, err := c.do(c.client, method, rawURL)
+	if err == nil && method == http.MethodHead &&
+		(resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented) {
+		resp.Body.Close()
+		resp, err = c.do(c.client, http.MethodGet, rawURL)
+	}
+	return resp, err
+}
+
+// isRedirect reports whether a status code redirects to the response's Location
+func isRedirect(statusCode int) bool {
+	switch statusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// resolveLocation resolves a Location header against the URL that sent it
+func resolveLocation(base, location string) (string, error) {
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	ref, err := url.Parse(location)
+	if err != nil {
+		return "", err
+	}
+	next := baseURL.ResolveReference(ref)
+	if next.Scheme != "http" && next.Scheme != "https" {
+		return "", fmt.Errorf("unsupported scheme %q", next.Scheme)
+	}
+	next.Fragment = ""
+	return next.String(), nil
+}
+
+// fetch reads a sitemap index or sitemap
+func (c *httpChecker) fetch(rawURL string) ([]byte, error) {
+	resp, err := c.do(c.fetchClient, http.MethodGet, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code %d", resp.StatusCode)
+	}
+
+	// Read one byte past the limit to detect oversized files; gzipped files over
+	// the limit are too large uncompressed as well
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSitemapBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > maxSitemapBytes {
+		return nil, errSitemapTooLarge
+	}
+	return content, nil
+}
+
+// do sends a request, retrying network errors, 429 and 5xx responses with
+// exponential backoff or the server's Retry-After delay
+func (c *httpChecker) do(client *http.Client, method, rawURL string) (*http.Response, error) {
+	backoff := time.Duration(c.options.RetryBackoffMS) * time.Millisecond
+
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(client, method, rawURL)
+		retry := err != nil || resp.StatusCode == http.StatusTooManyRequests ||
+			(resp.StatusCode >= 500 && resp.StatusCode != http.StatusNotImplemented)
+		if !retry || attempt >= c.options.Retries {
+			return resp, err
+		}
+
+		delay := backoff << attempt
+		if resp != nil {
+			if retryAfter := parseRetryAfter(resp.Header.Get("Retry-After")); retryAfter > 0 {
+				delay = retryAfter
+			}
+			io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+		time.Sleep(delay)
+	}
+}
+
+// send sends one request once the host's rate and the job's concurrency allow it
+func (c *httpChecker) send(client *http.Client, method, rawURL string) (*http.Response, error) {
+	req, err := http.NewRequest(method, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", c.options.UserAgent)
+	if c.sendsHeaders(req.URL) {
+		for name, value := range c.headers {
+			req.Header.Set(name, value)
+		}
+	}
+
+	c.wait(req.URL)
+	c.slots <- struct{}{}
+	resp, err := client.Do(req)
+	if err != nil {
+		<-c.slots
+		return nil, err
+	}
+	// The slot is held until the body is read and closed
+	resp.Body = &slotBody{ReadCloser: resp.Body, release: func() { <-c.slots }}
+	return resp, nil
+}
+
+// slotBody releases a concurrency slot when the response body is closed
+type slotBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *slotBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// wait sleeps until the job's request rate allows the next request to the URL's host
+func (c *httpChecker) wait(u *url.URL) {
+	if c.options.RequestsPerSecond <= 0 {
+		return
+	}
+	interval := time.Duration(float64(time.Second) / c.options.RequestsPerSecond)
+	host := strings.ToLower(u.Host)
+
+	c.mu.Lock()
+	next := c.nextFetch[host]
+	if now := time.Now(); next.Before(now) {
+		next = now
+	}
+	c.nextFetch[host] = next.Add(interval)
+	c.mu.Unlock()
+
+	time.Sleep(time.Until(next))
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as a date,
+// capped at maxRetryAfter
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	var delay time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		delay = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		delay = time.Until(date)
+	}
+	if delay > maxRetryAfter {
+		delay = maxRetryAfter
+	}
+	return delay
+}
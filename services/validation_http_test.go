@@ -0,0 +1,264 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sitemap-builder/models"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestChecker returns a checker with the given options, defaults filled in,
+// sending its headers to the given hosts
+func newTestChecker(t *testing.T, options models.ValidationOptions, headerHosts ...string) *httpChecker {
+	t.Helper()
+	if err := NormalizeValidationOptions(&options); err != nil {
+		t.Fatal(err)
+	}
+	c, err := newHTTPChecker(options, headerHosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// requestLog records the requests a test server received
+type requestLog struct {
+	mu       sync.Mutex
+	requests []string
+}
+
+func (l *requestLog) add(request string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.requests = append(l.requests, request)
+}
+
+func (l *requestLog) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.requests = nil
+}
+
+func (l *requestLog) get() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.requests...)
+}
+
+// localhostURL returns a URL of the test server under the host name localhost
+// instead of 127.0.0.1: the same server, but another host to the checker
+func localhostURL(t *testing.T, server *httptest.Server, path string) string {
+	t.Helper()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "http://localhost:" + u.Port() + path
+}
+
+func TestHeadersOnlySentToHeaderHosts(t *testing.T) {
+	var log requestLog
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		log.add(r.Host + r.URL.Path + " " + r.Header.Get("Authorization"))
+	})
+	mux.HandleFunc("/away", func(w http.ResponseWriter, r *http.Request) {
+		log.add(r.Host + r.URL.Path + " " + r.Header.Get("Authorization"))
+		http.Redirect(w, r, localhostURL(t, server, "/page"), http.StatusFound)
+	})
+
+	options := models.ValidationOptions{Headers: models.ValidationHeaders{"Authorization": "Bearer token"}}
+	c := newTestChecker(t, options, "127.0.0.1")
+	host := strings.TrimPrefix(server.URL, "http://")
+	other := strings.TrimPrefix(localhostURL(t, server, ""), "http://")
+
+	tests := []struct {
+		name string
+		run  func() error
+		want []string
+	}{
+		{"same host", func() error { return c.check(server.URL + "/page").err }, []string{host + "/page Bearer token"}},
+		{"other host", func() error { return c.check(localhostURL(t, server, "/page")).err }, []string{other + "/page "}},
+		{"check redirected to another host", func() error { return c.check(server.URL + "/away").err },
+			[]string{host + "/away Bearer token", other + "/page "}},
+		{"fetch redirected to another host", func() error { _, err := c.fetch(server.URL + "/away"); return err },
+			[]string{host + "/away Bearer token", other + "/page "}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log.reset()
+			if err := tt.run(); err != nil {
+				t.Fatal(err)
+			}
+			if got := log.get(); strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("server received %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckFallsBackToGET(t *testing.T) {
+	var log requestLog
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.add(r.Method)
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer server.Close()
+
+	check := newTestChecker(t, models.ValidationOptions{}).check(server.URL)
+	if check.status != "OK" || check.statusCode != http.StatusOK {
+		t.Errorf("check = %s %d (%v), want OK 200", check.status, check.statusCode, check.err)
+	}
+	if got := strings.Join(log.get(), ","); got != "HEAD,GET" {
+		t.Errorf("methods sent: %s, want HEAD,GET", got)
+	}
+}
+
+func TestCheckRetries(t *testing.T) {
+	tests := []struct {
+		name       string
+		responses  []int
+		retryAfter string
+		retries    int
+		wantStatus int
+		wantTries  int
+		minDelay   time.Duration
+	}{
+		{"recovers", []int{503, 200}, "", 2, 200, 2, 0},
+		{"gives up", []int{503, 503, 503, 200}, "", 2, 503, 3, 0},
+		{"no retries", []int{429, 200}, "", 0, 429, 1, 0},
+		{"client errors are not retried", []int{404, 200}, "", 2, 404, 1, 0},
+		{"waits for Retry-After", []int{429, 200}, "1", 1, 200, 2, time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			tries := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				status := tt.responses[tries]
+				tries++
+				mu.Unlock()
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.WriteHeader(status)
+			}))
+			defer server.Close()
+
+			c := newTestChecker(t, models.ValidationOptions{Retries: tt.retries, RetryBackoffMS: 1})
+			start := time.Now()
+			check := c.check(server.URL)
+			if check.statusCode != tt.wantStatus {
+				t.Errorf("status code %d, want %d", check.statusCode, tt.wantStatus)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if tries != tt.wantTries {
+				t.Errorf("sent %d requests, want %d", tries, tt.wantTries)
+			}
+			if elapsed := time.Since(start); elapsed < tt.minDelay {
+				t.Errorf("retried after %v, want at least %v", elapsed, tt.minDelay)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value    string
+		min, max time.Duration
+	}{
+		{"", 0, 0},
+		{"3", 3 * time.Second, 3 * time.Second},
+		{"3600", maxRetryAfter, maxRetryAfter},
+		{"soon", 0, 0},
+		{time.Now().Add(10 * time.Second).UTC().Format(http.TimeFormat), 8 * time.Second, 10 * time.Second},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), -2 * time.Hour, 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value); got < tt.min || got > tt.max {
+			t.Errorf("parseRetryAfter(%q) = %v, want between %v and %v", tt.value, got, tt.min, tt.max)
+		}
+	}
+}
+
+func TestCheckRedirects(t *testing.T) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	mux.HandleFunc("/old", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/moved#section", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, server.URL+"/new", http.StatusFound)
+	})
+	mux.HandleFunc("/new", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/loop-a", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop-b", http.StatusFound)
+	})
+	mux.HandleFunc("/loop-b", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop-a", http.StatusFound)
+	})
+	mux.HandleFunc("/chain/", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, r.URL.Path+"x", http.StatusTemporaryRedirect)
+	})
+
+	tests := []struct {
+		path       string
+		wantStatus string
+		wantHops   int
+		wantFinal  string
+		wantIssue  string
+	}{
+		{"/new", "OK", 0, "/new", ""},
+		{"/old", "OK", 2, "/new", "redirected"},
+		{"/loop-a", "ERROR", 2, "/loop-b", "redirect_loop"},
+		{"/chain/", "ERROR", maxRedirects, "/chain/" + strings.Repeat("x", maxRedirects-1), "too_many_redirects"},
+	}
+	c := newTestChecker(t, models.ValidationOptions{})
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			check := c.check(server.URL + tt.path)
+			if check.status != tt.wantStatus {
+				t.Errorf("status %s (%v), want %s", check.status, check.err, tt.wantStatus)
+			}
+			if len(check.redirects) != tt.wantHops {
+				t.Errorf("%d hops recorded, want %d: %+v", len(check.redirects), tt.wantHops, check.redirects)
+			}
+			if check.finalURL != server.URL+tt.wantFinal {
+				t.Errorf("final URL %s, want %s", check.finalURL, server.URL+tt.wantFinal)
+			}
+			var codes []string
+			for _, issue := range urlCheckIssues(server.URL+tt.path, check) {
+				codes = append(codes, issue.Code)
+			}
+			if got := strings.Join(codes, ","); got != tt.wantIssue {
+				t.Errorf("issues %q, want %q", got, tt.wantIssue)
+			}
+		})
+	}
+
+	// Each hop is recorded with its status code and Location
+	check := c.check(server.URL + "/old")
+	want := []models.RedirectHop{
+		{URL: server.URL + "/old", StatusCode: http.StatusMovedPermanently, Location: "/moved#section"},
+		{URL: server.URL + "/moved", StatusCode: http.StatusFound, Location: server.URL + "/new"},
+	}
+	if len(check.redirects) != len(want) {
+		t.Fatalf("hops %+v, want %+v", check.redirects, want)
+	}
+	for i := range want {
+		if check.redirects[i] != want[i] {
+			t.Errorf("hop %d = %+v, want %+v", i, check.redirects[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package services
+
+import (
+	"sitemap-builder/models"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// ValidationResultFilter selects and orders the results of a validation job
+type ValidationResultFilter struct {
+	Status        string `query:"status"`
+	Type          string `query:"type"`
+	Sitemap       string `query:"sitemap"`
+	Error         string `query:"error"` // substring of the error message
+	MinStatusCode int    `query:"min_status_code"`
+	MaxStatusCode int    `query:"max_status_code"`
+	Sort          string `query:"sort"` // a sortable column, prefixed with "-" for descending order
+	Page          int    `query:"page"`
+	PerPage       int    `query:"per_page"`
+}
+
+var sortableResultColumns = map[string]bool{
+	"id": true, "url": true, "type": true, "sitemap": true, "status": true, "status_code": true,
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// filteredResults applies a filter's conditions to a query of a job's results
+func filteredResults(db *gorm.DB, jobID uint, filter ValidationResultFilter) *gorm.DB {
+	query := db.Model(&models.ValidationResult{}).Where("job_id = ?", jobID)
+	if filter.Status != "" {
+		query = query.Where("status = ?", strings.ToUpper(filter.Status))
+	}
+	if filter.Type != "" {
+		query = query.Where("type = ?", filter.Type)
+	}
+	if filter.Sitemap != "" {
+		query = query.Where("sitemap = ?", filter.Sitemap)
+	}
+	if filter.Error != "" {
+		query = query.Where(`error LIKE ? ESCAPE '\'`, "%"+escapeLike(filter.Error)+"%")
+	}
+	if filter.MinStatusCode > 0 {
+		query = query.Where("status_code >= ?", filter.MinStatusCode)
+	}
+	if filter.MaxStatusCode > 0 {
+		query = query.Where("status_code <= ?", filter.MaxStatusCode)
+	}
+	return query
+}
+
+// QueryValidationResults returns one page of a job's results matching the filter
+// and the number of matching results
+func QueryValidationResults(db *gorm.DB, jobID uint, filter ValidationResultFilter) ([]models.ValidationResult, int64, error) {
+	var total int64
+	if err := filteredResults(db, jobID, filter).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	order := "id"
+	column := strings.TrimPrefix(filter.Sort, "-")
+	if sortableResultColumns[column] {
+		order = column
+		if strings.HasPrefix(filter.Sort, "-") {
+			order += " desc"
+		}
+	}
+
+	var results []models.ValidationResult
+	err := filteredResults(db, jobID, filter).
+		Order(order).
+		Limit(filter.PerPage).
+		Offset((filter.Page - 1) * filter.PerPage).
+		Find(&results).Error
+	return results, total, err
+}
+
+// StatusCodeCount is the number of results of a job with a status code
+type StatusCodeCount struct {
+	StatusCode int   `json:"status_code"`
+	Count      int64 `json:"count"`
+}
+
+// ValidationResultsByStatusCode counts the results of a job per status code
+func ValidationResultsByStatusCode(db *gorm.DB, jobID uint) ([]StatusCodeCount, error) {
+	var counts []StatusCodeCount
+	err := db.Model(&models.ValidationResult{}).
+		Select("status_code, count(*) as count").
+		Where("job_id = ?", jobID).
+		Group("status_code").
+		Order("status_code").
+		Scan(&counts).Error
+	return counts, err
+}
+
+// SitemapCount is the number of URL results of a job listed in a sitemap.
+// Skipped URLs only count towards the total.
+type SitemapCount struct {
+	Sitemap    string `json:"sitemap"`
+	Total      int64  `json:"total"`
+	OKCount    int64  `json:"ok_count"`
+	ErrorCount int64  `json:"error_count"`
+}
+
+// ValidationResultsBySitemap counts the results of a job per sitemap they were listed in
+func ValidationResultsBySitemap(db *gorm.DB, jobID uint) ([]SitemapCount, error) {
+	var counts []SitemapCount
+	err := db.Model(&models.ValidationResult{}).
+		Select("sitemap, count(*) as total, "+
+			"sum(case when status = 'OK' then 1 else 0 end) as ok_count, "+
+			"sum(case when status = 'ERROR' then 1 else 0 end) as error_count").
+		Where("job_id = ?", jobID).
+		Group("sitemap").
+		Order("sitemap").
+		Scan(&counts).Error
+	return counts, err
+}
+
+// ValidationIssueFilter selects the protocol issues of a validation job
+type ValidationIssueFilter struct {
+	Severity   string `query:"severity"`
+	Code       string `query:"code"`
+	SitemapURL string `query:"sitemap_url"`
+	Page       int    `query:"page"`
+	PerPage    int    `query:"per_page"`
+}
+
+// filteredIssues applies a filter's conditions to a query of a job's issues
+func filteredIssues(db *gorm.DB, jobID uint, filter ValidationIssueFilter) *gorm.DB {
+	query := db.Model(&models.ValidationIssue{}).Where("job_id = ?", jobID)
+	if filter.Severity != "" {
+		query = query.Where("severity = ?", strings.ToLower(filter.Severity))
+	}
+	if filter.Code != "" {
+		query = query.Where("code = ?", filter.Code)
+	}
+	if filter.SitemapURL != "" {
+		query = query.Where("sitemap_url = ?", filter.SitemapURL)
+	}
+	return query
+}
+
+// QueryValidationIssues returns one page of a job's issues matching the filter
+// and the number of matching issues
+func QueryValidationIssues(db *gorm.DB, jobID uint, filter ValidationIssueFilter) ([]models.ValidationIssue, int64, error) {
+	var total int64
+	if err := filteredIssues(db, jobID, filter).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var issues []models.ValidationIssue
+	err := filteredIssues(db, jobID, filter).
+		Order("id").
+		Limit(filter.PerPage).
+		Offset((filter.Page - 1) * filter.PerPage).
+		Find(&issues).Error
+	return issues, total, err
+}
+
+// IssueCodeCount is the number of issues of a job with a code
+type IssueCodeCount struct {
+	Code     string `json:"code"`
+	Severity string `json:"severity"`
+	Count    int64  `json:"count"`
+}
+
+// ValidationIssuesByCode counts the issues of a job per code
+func ValidationIssuesByCode(db *gorm.DB, jobID uint) ([]IssueCodeCount, error) {
+	var counts []IssueCodeCount
+	err := db.Model(&models.ValidationIssue{}).
+		Select("code, severity, count(*) as count").
+		Where("job_id = ?", jobID).
+		Group("code, severity").
+		Order("severity, code").
+		Scan(&counts).Error
+	return counts, err
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"fmt"
+	"net/url"
+	"sitemap-builder/models"
+	"sitemap-builder/utils"
+	"strings"
+	"sync"
+)
+
+const defaultRobotsUserAgent = "Googlebot"
+
+// robotsHost holds the robots.txt rules of a host, fetched once per validation job
+type robotsHost struct {
+	once   sync.Once
+	robots *utils.Robots
+}
+
+// robotsFor returns the robots.txt rules of the URL's host. A robots.txt that
+// cannot be fetched is reported as an issue and allows everything.
+func (v *validator) robotsFor(u *url.URL) *utils.Robots {
+	host := strings.ToLower(u.Scheme + "://" + u.Host)
+
+	v.mu.Lock()
+	if v.robots == nil {
+		v.robots = make(map[string]*robotsHost)
+	}
+	entry, ok := v.robots[host]
+	if !ok {
+		entry = &robotsHost{}
+		v.robots[host] = entry
+	}
+	v.mu.Unlock()
+
+	entry.once.Do(func() {
+		robots, err := utils.FetchRobots(v.http.fetchClient, v.job.Options.UserAgent, u)
+		if err != nil {
+			v.recordIssues(host+"/robots.txt", []models.ValidationIssue{{Code: "robots_unavailable", Severity: "warning",
+				Message: fmt.Sprintf("robots.txt could not be fetched, URLs were not checked against it: %v", err)}})
+			robots = &utils.Robots{}
+		}
+		entry.robots = robots
+	})
+	return entry.robots
+}
+
+// robotsAllowed reports whether the job's robots user agent may crawl a URL
+func (v *validator) robotsAllowed(loc string) bool {
+	u, err := url.Parse(loc)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return true
+	}
+	return v.robotsFor(u).AllowedURL(v.job.Options.RobotsUserAgent, u)
+}
+
+// checkRobotsSitemap checks that the robots.txt of the target's host references the target
+func (v *validator) checkRobotsSitemap(target string) {
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return
+	}
+
+	robots := v.robotsFor(u)
+	for _, sitemap := range robots.Sitemaps {
+		if sameURL(sitemap, target) {
+			return
+		}
+	}
+	robotsURL := fmt.Sprintf("%s://%s/robots.txt", u.Scheme, u.Host)
+	v.recordIssues(robotsURL, []models.ValidationIssue{{URL: target, Code: "robots_missing_sitemap", Severity: "warning",
+		Message: "robots.txt does not reference the validated sitemap with a Sitemap: line"}})
+}
@@ -0,0 +1,88 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"sitemap-builder/models"
+	"sitemap-builder/storage"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// ErrUnknownStorageConfig is returned when a storage config is not a target of the sitemap index
+var ErrUnknownStorageConfig = errors.New("storage config is not a target of the sitemap index")
+
+// StartIndexValidation validates the generated files of a sitemap index, read
+// straight from one of its storage targets (the first one if storageConfigID
+// is 0), so they can be checked before they are served publicly
+func StartIndexValidation(db *gorm.DB, sitemapIndex *models.SitemapIndex, storageConfigID uint, options models.ValidationOptions, userID uint) (*models.ValidationJob, error) {
+	configs := indexStorageConfigs(sitemapIndex)
+	storageConfig := configs[0]
+	if storageConfigID != 0 {
+		found := false
+		for _, config := range configs {
+			if config.ID == storageConfigID {
+				storageConfig, found = config, true
+			}
+		}
+		if !found {
+			return nil, ErrUnknownStorageConfig
+		}
+	}
+
+	store, err := storage.Open(storageConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &models.ValidationJob{
+		Target:          sitemapIndex.Name + ".xml",
+		Source:          "storage",
+		SitemapIndexID:  sitemapIndex.ID,
+		StorageConfigID: storageConfig.ID,
+		UserID:          userID,
+	}
+	job, err = startValidationJob(db, job, options, store)
+	if err != nil {
+		store.Close()
+	}
+	return job, err
+}
+
+// readStoredSitemap reads a generated sitemap index or sitemap from storage. The
+// index file is stored under its name and sitemap files as <index>/<sitemap>/<file>,
+// which is how their public URL's path ends.
+func readStoredSitemap(store storage.Storage, indexName, sitemapURL string) ([]byte, error) {
+	p := sitemapURL
+	if u, err := url.Parse(sitemapURL); err == nil && u.Path != "" {
+		p = u.Path
+	}
+	key := path.Base(p)
+	if segments := strings.Split(p, "/"); len(segments) >= 3 && segments[len(segments)-3] == indexName {
+		key = strings.Join(segments[len(segments)-3:], "/")
+	}
+
+	r, err := store.Open(context.Background(), key)
+	if err == storage.ErrNotExist {
+		return nil, fmt.Errorf("%s does not exist in storage", key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	// Read one byte past the limit to detect oversized files
+	content, err := io.ReadAll(io.LimitReader(r, maxSitemapBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > maxSitemapBytes {
+		return nil, errSitemapTooLarge
+	}
+	return content, nil
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sitemap-builder/models"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// runTestValidation runs a validation job of target to completion and returns
+// the job and its results in the order they were recorded
+func runTestValidation(t *testing.T, target string, options models.ValidationOptions) (models.ValidationJob, []models.ValidationResult) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to :memory: opens a database of its own
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.AutoMigrate(&models.ValidationJob{}, &models.ValidationResult{}, &models.ValidationIssue{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NormalizeValidationOptions(&options); err != nil {
+		t.Fatal(err)
+	}
+	job := &models.ValidationJob{JobID: "test", Target: target, Source: "http", State: "pending", Options: options}
+	if err := db.Create(job).Error; err != nil {
+		t.Fatal(err)
+	}
+	runValidation(db, job, nil)
+
+	var saved models.ValidationJob
+	db.First(&saved, job.ID)
+	var results []models.ValidationResult
+	db.Where("job_id = ?", job.ID).Order("id").Find(&results)
+	return saved, results
+}
+
+// urlResult returns the result of the URL check of loc
+func urlResult(t *testing.T, results []models.ValidationResult, loc string) models.ValidationResult {
+	t.Helper()
+	for _, result := range results {
+		if result.Type == "url" && result.URL == loc {
+			return result
+		}
+	}
+	t.Fatalf("no result for %s in %+v", loc, results)
+	return models.ValidationResult{}
+}
+
+func TestValidationTrimsURLLocs(t *testing.T) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	mux.HandleFunc("/sitemap.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url>
+    <loc>
+      %s/page
+    </loc>
+  </url>
+</urlset>`, server.URL)
+	})
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {})
+
+	job, results := runTestValidation(t, server.URL+"/sitemap.xml", models.ValidationOptions{})
+	if job.State != "done" {
+		t.Fatalf("job %s: %s", job.State, job.Error)
+	}
+	if result := urlResult(t, results, server.URL+"/page"); result.Status != "OK" {
+		t.Errorf("padded loc checked as %s: %s", result.Status, result.Error)
+	}
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"sitemap-builder/models"
+	"sitemap-builder/storage"
+	"strings"
+)
+
+// publishedSums describes a file as it was generated
+type publishedSums struct {
+	size   int64
+	md5    string
+	sha256 string
+}
+
+var md5ETagPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// verifyObject checks that the object stored at key matches the generated file
+func verifyObject(ctx context.Context, config models.StorageConfig, store storage.Storage, key string, sums publishedSums) error {
+	info, err := store.Stat(ctx, key)
+	if err != nil {
+		return err
+	}
+	if info.Size != sums.size {
+		return fmt.Errorf("stored size %d, expected %d", info.Size, sums.size)
+	}
+
+	if config.Verify == "checksum" && etagIsMD5(config, info.ETag) {
+		if !strings.EqualFold(info.ETag, sums.md5) {
+			return fmt.Errorf("stored MD5 %s, expected %s", info.ETag, sums.md5)
+		}
+		return nil
+	}
+
+	// Backends without a comparable checksum are read back
+	return readBack(ctx, store, key, sums)
+}
+
+// etagIsMD5 reports whether the ETag is the MD5 of the content. S3 ETags of
+// multipart or KMS encrypted uploads are not, nor are those of other backends.
+func etagIsMD5(config models.StorageConfig, etag string) bool {
+	switch config.Mode {
+	case "s3":
+		return !strings.HasPrefix(config.ServerSideEncryption, "aws:kms") && md5ETagPattern.MatchString(etag)
+	case "memory":
+		return true
+	}
+	return false
+}
+
+// readBack reads the object, comparing its SHA-256 and re-parsing the XML
+func readBack(ctx context.Context, store storage.Storage, key string, sums publishedSums) error {
+	r, err := store.Open(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	hash := sha256.New()
+	decoder := xml.NewDecoder(io.TeeReader(r, hash))
+	for {
+		if _, err := decoder.Token(); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("stored XML does not parse: %v", err)
+		}
+	}
+	// Drain anything the decoder did not need
+	if _, err := io.Copy(hash, r); err != nil {
+		return err
+	}
+
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != sums.sha256 {
+		return fmt.Errorf("stored SHA-256 %s, expected %s", sum, sums.sha256)
+	}
+	return nil
+}
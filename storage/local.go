@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sitemap-builder/models"
+	"strings"
+)
+
+func init() {
+	Register("local", newLocalStorage)
+}
+
+// localStorage stores objects as files below a directory, which is the
+// config's Path inside LOCAL_STORAGE_ROOT (the working directory by default)
+type localStorage struct {
+	root string
+}
+
+func newLocalStorage(config models.StorageConfig) (Storage, error) {
+	base := os.Getenv("LOCAL_STORAGE_ROOT")
+	if base == "" {
+		base = "."
+	}
+	root, err := localPath(base, config.Path)
+	if err != nil {
+		return nil, err
+	}
+	return &localStorage{root: root}, nil
+}
+
+// localPath joins a slash separated path to dir, refusing paths that would
+// end up outside of dir
+func localPath(dir, p string) (string, error) {
+	full := filepath.Join(dir, filepath.FromSlash(p))
+	rel, err := filepath.Rel(dir, full)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path %q is outside of %s", p, dir)
+	}
+	return full, nil
+}
+
+func (s *localStorage) path(key string) (string, error) {
+	return localPath(s.root, key)
+}
+
+func (s *localStorage) Put(ctx context.Context, key string, r io.Reader, opts PutOptions) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+
+	// Write next to the destination and rename, which replaces it atomically
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, r)
+	if err == nil {
+		// Temporary files are only readable by their owner
+		err = file.Chmod(0644)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
+}
+
+func (s *localStorage) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *localStorage) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	// Walk the deepest directory the prefix names, then filter on the full prefix
+	dir := s.root
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		var err error
+		if dir, err = s.path(prefix[:i]); err != nil {
+			return nil, err
+		}
+	}
+
+	var objects []ObjectInfo
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.root, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{Key: key, Size: info.Size(), LastModified: info.ModTime()})
+		return nil
+	})
+	return objects, err
+}
+
+func (s *localStorage) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && info.IsDir()) {
+		return ObjectInfo{}, ErrNotExist
+	}
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{Key: key, Size: info.Size(), LastModified: info.ModTime()}, nil
+}
+
+func (s *localStorage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotExist
+	}
+	return file, err
+}
+
+func (s *localStorage) Close() error {
+	return nil
+}
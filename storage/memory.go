@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"sitemap-builder/models"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	Register("memory", func(config models.StorageConfig) (Storage, error) {
+		return MemoryStore(config.ID), nil
+	})
+}
+
+var (
+	memoryMu     sync.Mutex
+	memoryStores = make(map[uint]*memoryStorage)
+)
+
+// MemoryStore returns the in-memory backend of a storage config. Objects live
+// as long as the process, so it is meant for tests and trying things out.
+func MemoryStore(storageConfigID uint) Storage {
+	memoryMu.Lock()
+	defer memoryMu.Unlock()
+
+	store, ok := memoryStores[storageConfigID]
+	if !ok {
+		store = &memoryStorage{objects: make(map[string]memoryObject)}
+		memoryStores[storageConfigID] = store
+	}
+	return store
+}
+
+type memoryObject struct {
+	data         []byte
+	lastModified time.Time
+}
+
+type memoryStorage struct {
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+}
+
+func (s *memoryStorage) Put(ctx context.Context, key string, r io.Reader, opts PutOptions) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = memoryObject{data: data, lastModified: time.Now()}
+	return nil
+}
+
+func (s *memoryStorage) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, key)
+	return nil
+}
+
+func (s *memoryStorage) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var objects []ObjectInfo
+	for key, object := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, object.info(key))
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (s *memoryStorage) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	object, ok := s.objects[key]
+	if !ok {
+		return ObjectInfo{}, ErrNotExist
+	}
+	return object.info(key), nil
+}
+
+func (s *memoryStorage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	object, ok := s.objects[key]
+	if !ok {
+		return nil, ErrNotExist
+	}
+	return io.NopCloser(bytes.NewReader(object.data)), nil
+}
+
+func (s *memoryStorage) Close() error {
+	return nil
+}
+
+func (o memoryObject) info(key string) ObjectInfo {
+	sum := md5.Sum(o.data)
+	return ObjectInfo{
+		Key:          key,
+		Size:         int64(len(o.data)),
+		LastModified: o.lastModified,
+		ETag:         hex.EncodeToString(sum[:]),
+	}
+}
@@ -0,0 +1,221 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sitemap-builder/models"
+	"sitemap-builder/secrets"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+)
+
+func init() {
+	Register("s3", newS3Storage)
+}
+
+// s3Storage stores objects in an S3 compatible bucket, below the config's Path
+type s3Storage struct {
+	client *s3.Client
+	config models.StorageConfig
+}
+
+type s3ClientEntry struct {
+	client    *s3.Client
+	updatedAt time.Time
+}
+
+var (
+	s3ClientsMu sync.Mutex
+	s3Clients   = make(map[uint]s3ClientEntry)
+)
+
+func newS3Storage(storageConfig models.StorageConfig) (Storage, error) {
+	if err := checkPath(storageConfig.Path, false); err != nil {
+		return nil, err
+	}
+	client, err := s3Client(storageConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &s3Storage{client: client, config: storageConfig}, nil
+}
+
+// s3Client returns the cached client of a storage config, creating it on first
+// use or when the config was updated since
+func s3Client(storageConfig models.StorageConfig) (*s3.Client, error) {
+	s3ClientsMu.Lock()
+	defer s3ClientsMu.Unlock()
+
+	if entry, ok := s3Clients[storageConfig.ID]; ok && entry.updatedAt.Equal(storageConfig.UpdatedAt) {
+		return entry.client, nil
+	}
+
+	client, err := newS3Client(storageConfig)
+	if err != nil {
+		return nil, err
+	}
+	// Unsaved configs (ID 0) are not cached
+	if storageConfig.ID != 0 {
+		s3Clients[storageConfig.ID] = s3ClientEntry{client: client, updatedAt: storageConfig.UpdatedAt}
+	}
+	return client, nil
+}
+
+func newS3Client(storageConfig models.StorageConfig) (*s3.Client, error) {
+	ctx := context.Background()
+
+	accessKeyID, secretAccessKey, err := S3Credentials(storageConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	options := []func(*config.LoadOptions) error{config.WithRegion(storageConfig.Region)}
+	if accessKeyID != "" {
+		options = append(options, config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
+			accessKeyID,
+			secretAccessKey,
+			"",
+		)))
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	if storageConfig.RoleARN != "" {
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), storageConfig.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = "sitemap-builder"
+		})
+		cfg.Credentials = aws.NewCredentialsCache(provider)
+	}
+
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if storageConfig.Endpoint != "" {
+			o.BaseEndpoint = aws.String(storageConfig.Endpoint)
+		}
+		o.UsePathStyle = storageConfig.UsePathStyle
+	}), nil
+}
+
+// S3Credentials resolves the access key of a storage config, which may be given as
+// secret references. Without one, the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY
+// environment variables are used, and if those are unset too the AWS default
+// credential chain applies (signalled by an empty access key ID).
+func S3Credentials(storageConfig models.StorageConfig) (string, string, error) {
+	if storageConfig.AccessKeyID == "" {
+		return os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"), nil
+	}
+
+	accessKeyID, err := secrets.Resolve(storageConfig.AccessKeyID)
+	if err != nil {
+		return "", "", err
+	}
+	secretAccessKey, err := secrets.Resolve(string(storageConfig.SecretAccessKey))
+	if err != nil {
+		return "", "", err
+	}
+	return accessKeyID, secretAccessKey, nil
+}
+
+// Put uploads small objects with a single request and streams larger ones as
+// multipart uploads, holding at most MultipartConcurrency+1 parts in memory
+func (s *s3Storage) Put(ctx context.Context, key string, r io.Reader, opts PutOptions) error {
+	partSize := s.partSize()
+	first, err := readPart(r, partSize)
+	if err != nil {
+		return err
+	}
+	if int64(len(first)) < partSize {
+		return s.putObject(ctx, key, first, opts)
+	}
+	return s.multipartUpload(ctx, key, first, r, opts)
+}
+
+func (s *s3Storage) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.config.Bucket),
+		Key:    aws.String(s.config.Path + key),
+	})
+	return err
+}
+
+func (s *s3Storage) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.config.Bucket),
+		Prefix: aws.String(s.config.Path + prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range page.Contents {
+			objects = append(objects, ObjectInfo{
+				Key:          strings.TrimPrefix(aws.ToString(object.Key), s.config.Path),
+				Size:         aws.ToInt64(object.Size),
+				LastModified: aws.ToTime(object.LastModified),
+				ETag:         strings.Trim(aws.ToString(object.ETag), `"`),
+			})
+		}
+	}
+	return objects, nil
+}
+
+func (s *s3Storage) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	head, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.config.Bucket),
+		Key:    aws.String(s.config.Path + key),
+	})
+	if err != nil {
+		return ObjectInfo{}, s3Error(err)
+	}
+	return ObjectInfo{
+		Key:          key,
+		Size:         aws.ToInt64(head.ContentLength),
+		LastModified: aws.ToTime(head.LastModified),
+		ETag:         strings.Trim(aws.ToString(head.ETag), `"`),
+	}, nil
+}
+
+func (s *s3Storage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	object, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.config.Bucket),
+		Key:    aws.String(s.config.Path + key),
+	})
+	if err != nil {
+		return nil, s3Error(err)
+	}
+	return object.Body, nil
+}
+
+func (s *s3Storage) Close() error {
+	return nil
+}
+
+// s3Error maps missing object errors to ErrNotExist
+func s3Error(err error) error {
+	var noSuchKey *types.NoSuchKey
+	var notFound *types.NotFound
+	if errors.As(err, &noSuchKey) || errors.As(err, &notFound) {
+		return ErrNotExist
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NotFound" {
+		return ErrNotExist
+	}
+	return err
+}
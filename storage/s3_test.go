@@ -0,0 +1,245 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sitemap-builder/models"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeS3 is a minimal S3 compatible server covering the object and multipart
+// upload calls the s3 driver makes, with path style addressing
+type fakeS3 struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	uploads  map[string]map[int][]byte
+	nextID   int
+	puts     int
+	aborted  int
+	complete int
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *httptest.Server) {
+	f := &fakeS3{objects: make(map[string][]byte), uploads: make(map[string]map[int][]byte)}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	return f, server
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	query := r.URL.Query()
+	uploadID := query.Get("uploadId")
+
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.nextID++
+		id := strconv.Itoa(f.nextID)
+		f.uploads[id] = make(map[int][]byte)
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>", key, id)
+
+	case r.Method == http.MethodPut && uploadID != "":
+		parts, ok := f.uploads[uploadID]
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchUpload</Code></Error>", http.StatusNotFound)
+			return
+		}
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		body, _ := io.ReadAll(r.Body)
+		parts[number] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"part-%d"`, number))
+
+	case r.Method == http.MethodPost && uploadID != "":
+		parts, ok := f.uploads[uploadID]
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchUpload</Code></Error>", http.StatusNotFound)
+			return
+		}
+		var request struct {
+			Parts []struct {
+				PartNumber int
+			} `xml:"Part"`
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "<Error><Code>MalformedXML</Code></Error>", http.StatusBadRequest)
+			return
+		}
+		var object []byte
+		for _, part := range request.Parts {
+			object = append(object, parts[part.PartNumber]...)
+		}
+		f.objects[key] = object
+		delete(f.uploads, uploadID)
+		f.complete++
+		fmt.Fprintf(w, "<CompleteMultipartUploadResult><Key>%s</Key><ETag>\"done\"</ETag></CompleteMultipartUploadResult>", key)
+
+	case r.Method == http.MethodDelete && uploadID != "":
+		delete(f.uploads, uploadID)
+		f.aborted++
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		f.objects[key] = body
+		f.puts++
+		w.Header().Set("ETag", `"object"`)
+
+	case r.Method == http.MethodGet:
+		object, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "<Error><Code>NoSuchKey</Code></Error>")
+			return
+		}
+		w.Write(object)
+
+	default:
+		http.Error(w, "<Error><Code>NotImplemented</Code></Error>", http.StatusNotImplemented)
+	}
+}
+
+func newTestS3Storage(t *testing.T, server *httptest.Server) Storage {
+	store, err := newS3Storage(models.StorageConfig{
+		Bucket:               "bucket",
+		Region:               "us-east-1",
+		Endpoint:             server.URL,
+		UsePathStyle:         true,
+		Path:                 "sitemaps/",
+		AccessKeyID:          "test",
+		SecretAccessKey:      "test",
+		MultipartPartSizeMB:  5,
+		MultipartConcurrency: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+// testData returns n bytes that differ from part to part, so misordered parts are caught
+func testData(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i / 1024 % 251)
+	}
+	return data
+}
+
+func TestS3PutUploads(t *testing.T) {
+	const mib = 1 << 20
+	tests := []struct {
+		name      string
+		size      int
+		multipart bool
+	}{
+		{"small file", 1024, false},
+		{"just below one part", 5*mib - 1, false},
+		{"exactly one part", 5 * mib, true},
+		{"several parts", 12*mib + 17, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, server := newFakeS3(t)
+			store := newTestS3Storage(t, server)
+			data := testData(tt.size)
+
+			if err := store.Put(context.Background(), "a/b/b-0001.xml", bytes.NewReader(data), PutOptions{ContentType: "application/xml"}); err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+
+			object, ok := fake.objects["bucket/sitemaps/a/b/b-0001.xml"]
+			if !ok {
+				t.Fatalf("object not stored, have %v", keys(fake.objects))
+			}
+			if !bytes.Equal(object, data) {
+				t.Fatalf("stored %d bytes that differ from the %d put", len(object), len(data))
+			}
+			if got := fake.complete == 1; got != tt.multipart {
+				t.Errorf("multipart = %v, want %v", got, tt.multipart)
+			}
+			if got := fake.puts == 1; got == tt.multipart {
+				t.Errorf("single put = %v, want %v", got, !tt.multipart)
+			}
+			if len(fake.uploads) != 0 {
+				t.Errorf("%d uploads left open", len(fake.uploads))
+			}
+
+			r, err := store.Open(context.Background(), "a/b/b-0001.xml")
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			defer r.Close()
+			read, _ := io.ReadAll(r)
+			if !bytes.Equal(read, data) {
+				t.Errorf("read back %d bytes that differ from the %d put", len(read), len(data))
+			}
+		})
+	}
+}
+
+// failingReader returns n bytes and then err
+type failingReader struct {
+	r   io.Reader
+	err error
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if err == io.EOF {
+		return n, f.err
+	}
+	return n, err
+}
+
+func TestS3PutAbortsFailedMultipartUpload(t *testing.T) {
+	fake, server := newFakeS3(t)
+	store := newTestS3Storage(t, server)
+
+	readErr := errors.New("row source failed")
+	r := &failingReader{r: bytes.NewReader(testData(11 << 20)), err: readErr}
+	err := store.Put(context.Background(), "a.xml", r, PutOptions{})
+	if !errors.Is(err, readErr) {
+		t.Fatalf("Put error = %v, want %v", err, readErr)
+	}
+
+	if fake.aborted != 1 {
+		t.Errorf("aborted %d uploads, want 1", fake.aborted)
+	}
+	if len(fake.uploads) != 0 {
+		t.Errorf("%d uploads left open", len(fake.uploads))
+	}
+	if len(fake.objects) != 0 {
+		t.Errorf("objects stored after a failed upload: %v", keys(fake.objects))
+	}
+}
+
+func TestS3OpenMissingObject(t *testing.T) {
+	_, server := newFakeS3(t)
+	store := newTestS3Storage(t, server)
+
+	if _, err := store.Open(context.Background(), "missing.xml"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Open error = %v, want ErrNotExist", err)
+	}
+}
+
+func keys(objects map[string][]byte) []string {
+	var names []string
+	for name := range objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,211 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	// S3 rejects parts below 5 MiB (except the last) and uploads above 10000 parts
+	minPartSize     = 5 * 1024 * 1024
+	defaultPartSize = 8 * 1024 * 1024
+	maxParts        = 10000
+
+	defaultUploadConcurrency = 4
+)
+
+func (s *s3Storage) partSize() int64 {
+	partSize := int64(s.config.MultipartPartSizeMB) * 1024 * 1024
+	if partSize <= 0 {
+		return defaultPartSize
+	}
+	if partSize < minPartSize {
+		return minPartSize
+	}
+	return partSize
+}
+
+func (s *s3Storage) concurrency() int {
+	if s.config.MultipartConcurrency > 0 {
+		return s.config.MultipartConcurrency
+	}
+	return defaultUploadConcurrency
+}
+
+// readPart reads up to size bytes, returning fewer only at the end of the stream
+func readPart(r io.Reader, size int64) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(io.LimitReader(r, size)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (s *s3Storage) putObject(ctx context.Context, key string, data []byte, opts PutOptions) error {
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(s.config.Bucket),
+		Key:    aws.String(s.config.Path + key),
+		Body:   bytes.NewReader(data),
+	}
+	if opts.ContentType != "" {
+		input.ContentType = aws.String(opts.ContentType)
+	}
+	if s.config.ACL != "" {
+		input.ACL = types.ObjectCannedACL(s.config.ACL)
+	}
+	if s.config.CacheControl != "" {
+		input.CacheControl = aws.String(s.config.CacheControl)
+	}
+	if s.config.StorageClass != "" {
+		input.StorageClass = types.StorageClass(s.config.StorageClass)
+	}
+	if s.config.ServerSideEncryption != "" {
+		input.ServerSideEncryption = types.ServerSideEncryption(s.config.ServerSideEncryption)
+	}
+	if s.config.SSEKMSKeyID != "" {
+		input.SSEKMSKeyId = aws.String(s.config.SSEKMSKeyID)
+	}
+
+	_, err := s.client.PutObject(ctx, input)
+	return err
+}
+
+func (s *s3Storage) createMultipartUpload(ctx context.Context, key string, opts PutOptions) (string, error) {
+	input := &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(s.config.Bucket),
+		Key:    aws.String(s.config.Path + key),
+	}
+	if opts.ContentType != "" {
+		input.ContentType = aws.String(opts.ContentType)
+	}
+	if s.config.ACL != "" {
+		input.ACL = types.ObjectCannedACL(s.config.ACL)
+	}
+	if s.config.CacheControl != "" {
+		input.CacheControl = aws.String(s.config.CacheControl)
+	}
+	if s.config.StorageClass != "" {
+		input.StorageClass = types.StorageClass(s.config.StorageClass)
+	}
+	if s.config.ServerSideEncryption != "" {
+		input.ServerSideEncryption = types.ServerSideEncryption(s.config.ServerSideEncryption)
+	}
+	if s.config.SSEKMSKeyID != "" {
+		input.SSEKMSKeyId = aws.String(s.config.SSEKMSKeyID)
+	}
+
+	upload, err := s.client.CreateMultipartUpload(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(upload.UploadId), nil
+}
+
+// multipartUpload uploads first and the rest of r as parts, uploading up to
+// MultipartConcurrency parts in parallel. The upload is aborted if a part
+// fails, r fails or ctx is cancelled, so no partial object is left behind.
+func (s *s3Storage) multipartUpload(ctx context.Context, key string, first []byte, r io.Reader, opts PutOptions) error {
+	uploadID, err := s.createMultipartUpload(ctx, key, opts)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		parts     []types.CompletedPart
+		uploadErr error
+	)
+	fail := func(err error) {
+		mu.Lock()
+		if uploadErr == nil {
+			uploadErr = err
+		}
+		mu.Unlock()
+		cancel()
+	}
+
+	slots := make(chan struct{}, s.concurrency())
+	data := first
+	for partNumber := int32(1); len(data) > 0; partNumber++ {
+		if partNumber > maxParts {
+			fail(fmt.Errorf("upload of %s exceeds %d parts, increase the part size", key, maxParts))
+			break
+		}
+
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(partNumber int32, data []byte) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			part, err := s.client.UploadPart(ctx, &s3.UploadPartInput{
+				Bucket:     aws.String(s.config.Bucket),
+				Key:        aws.String(s.config.Path + key),
+				UploadId:   aws.String(uploadID),
+				PartNumber: aws.Int32(partNumber),
+				Body:       bytes.NewReader(data),
+			})
+			if err != nil {
+				fail(fmt.Errorf("uploading part %d: %w", partNumber, err))
+				return
+			}
+
+			mu.Lock()
+			parts = append(parts, types.CompletedPart{ETag: part.ETag, PartNumber: aws.Int32(partNumber)})
+			mu.Unlock()
+		}(partNumber, data)
+
+		if data, err = readPart(r, s.partSize()); err != nil {
+			fail(err)
+			break
+		}
+	}
+	wg.Wait()
+
+	if uploadErr == nil && ctx.Err() != nil {
+		uploadErr = ctx.Err()
+	}
+	if uploadErr == nil {
+		sort.Slice(parts, func(i, j int) bool {
+			return aws.ToInt32(parts[i].PartNumber) < aws.ToInt32(parts[j].PartNumber)
+		})
+		_, uploadErr = s.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(s.config.Bucket),
+			Key:             aws.String(s.config.Path + key),
+			UploadId:        aws.String(uploadID),
+			MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+		})
+	}
+
+	if uploadErr != nil {
+		// The request context may be cancelled already, abort regardless
+		_, abortErr := s.client.AbortMultipartUpload(context.Background(), &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(s.config.Bucket),
+			Key:      aws.String(s.config.Path + key),
+			UploadId: aws.String(uploadID),
+		})
+		if abortErr != nil {
+			return fmt.Errorf("%w (aborting upload %s also failed: %v)", uploadErr, uploadID, abortErr)
+		}
+	}
+	return uploadErr
+}
@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"path"
+	"sitemap-builder/models"
+	"sitemap-builder/secrets"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+func init() {
+	Register("sftp", newSFTPStorage)
+}
+
+// sftpStorage stores objects as files on an SFTP server, below the config's Path
+type sftpStorage struct {
+	conn   *ssh.Client
+	client *sftp.Client
+	prefix string
+}
+
+func newSFTPStorage(config models.StorageConfig) (Storage, error) {
+	if config.HostKey == "" {
+		return nil, fmt.Errorf("sftp storage requires the server's host key")
+	}
+	// Absolute paths are allowed, the login's permissions confine them
+	if err := checkPath(config.Path, true); err != nil {
+		return nil, err
+	}
+	hostKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(config.HostKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid host key: %v", err)
+	}
+
+	var auth []ssh.AuthMethod
+	if config.PrivateKey != "" {
+		privateKey, err := secrets.Resolve(string(config.PrivateKey))
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey([]byte(privateKey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid private key: %v", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if config.Password != "" {
+		password, err := secrets.Resolve(string(config.Password))
+		if err != nil {
+			return nil, err
+		}
+		auth = append(auth, ssh.Password(password))
+	}
+
+	addr := config.Endpoint
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+
+	conn, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            config.Username,
+		Auth:            auth,
+		HostKeyCallback: ssh.FixedHostKey(hostKey),
+		Timeout:         30 * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &sftpStorage{conn: conn, client: client, prefix: config.Path}, nil
+}
+
+func (s *sftpStorage) path(key string) string {
+	return s.prefix + key
+}
+
+func (s *sftpStorage) Put(ctx context.Context, key string, r io.Reader, opts PutOptions) error {
+	p := s.path(key)
+	if dir := path.Dir(p); dir != "." {
+		if err := s.client.MkdirAll(dir); err != nil {
+			return err
+		}
+	}
+
+	tmp := tempPath(p)
+	file, err := s.client.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = file.ReadFrom(r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = s.rename(tmp, p)
+	}
+	if err != nil {
+		s.client.Remove(tmp)
+	}
+	return err
+}
+
+// rename moves a file over an existing one. Plain SFTP renames fail if the target
+// exists, so the OpenSSH extension that replaces it atomically is used if the
+// server has it; otherwise the target is removed first.
+func (s *sftpStorage) rename(oldname, newname string) error {
+	if _, ok := s.client.HasExtension("posix-rename@openssh.com"); ok {
+		return s.client.PosixRename(oldname, newname)
+	}
+	if err := s.client.Remove(newname); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return s.client.Rename(oldname, newname)
+}
+
+func (s *sftpStorage) Delete(ctx context.Context, key string) error {
+	err := s.client.Remove(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *sftpStorage) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	// Walk the deepest directory the prefix names, then filter on the full prefix
+	full := s.path(prefix)
+	dir := "."
+	if i := strings.LastIndex(full, "/"); i >= 0 {
+		dir = full[:i+1]
+	}
+
+	var objects []ObjectInfo
+	walker := s.client.Walk(dir)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		info := walker.Stat()
+		if info.IsDir() {
+			continue
+		}
+
+		p := strings.TrimPrefix(walker.Path(), "./")
+		if !strings.HasPrefix(p, full) {
+			continue
+		}
+		objects = append(objects, ObjectInfo{
+			Key:          strings.TrimPrefix(p, s.prefix),
+			Size:         info.Size(),
+			LastModified: info.ModTime(),
+		})
+	}
+	return objects, nil
+}
+
+func (s *sftpStorage) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	info, err := s.client.Stat(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && info.IsDir()) {
+		return ObjectInfo{}, ErrNotExist
+	}
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{Key: key, Size: info.Size(), LastModified: info.ModTime()}, nil
+}
+
+func (s *sftpStorage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	file, err := s.client.Open(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotExist
+	}
+	return file, err
+}
+
+func (s *sftpStorage) Close() error {
+	s.client.Close()
+	return s.conn.Close()
+}
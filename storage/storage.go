@@ -0,0 +1,138 @@
+// Package storage abstracts the backends generated sitemaps are published to.
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sitemap-builder/models"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotExist is returned when an object does not exist in a backend
+var ErrNotExist = errors.New("storage: object does not exist")
+
+// Storage is a backend that sitemap files are published to and read back from.
+// Keys are slash separated paths relative to the backend's root.
+type Storage interface {
+	// Put writes the content of r to key, replacing any existing object only once
+	// r has been read completely; if r fails the existing object is kept
+	Put(ctx context.Context, key string, r io.Reader, opts PutOptions) error
+	// Delete removes the object at key; deleting a missing object is not an error
+	Delete(ctx context.Context, key string) error
+	// List returns the objects whose keys start with prefix
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	// Stat describes the object at key, or returns ErrNotExist
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+	// Open reads the object at key, or returns ErrNotExist
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Close releases connections held by the backend
+	Close() error
+}
+
+// PutOptions describe the object being written
+type PutOptions struct {
+	ContentType string
+}
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+	ETag         string    `json:"etag,omitempty"`
+}
+
+// Driver opens a backend for a storage config
+type Driver func(config models.StorageConfig) (Storage, error)
+
+var (
+	driversMu sync.RWMutex
+	drivers   = make(map[string]Driver)
+)
+
+// Register makes a driver available under the storage mode name
+func Register(mode string, driver Driver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	drivers[mode] = driver
+}
+
+// Drivers returns the names of the registered drivers
+func Drivers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkPath rejects a config's Path if it could escape the directory, key prefix
+// or collection it names: paths with ".." segments or backslashes, and absolute
+// paths unless allowAbsolute is set
+func checkPath(p string, allowAbsolute bool) error {
+	if strings.ContainsAny(p, "\\\x00") {
+		return fmt.Errorf("invalid path %q", p)
+	}
+	if !allowAbsolute && strings.HasPrefix(p, "/") {
+		return fmt.Errorf("path %q must be relative", p)
+	}
+	for _, segment := range strings.Split(p, "/") {
+		if segment == ".." {
+			return fmt.Errorf("path %q must not contain \"..\"", p)
+		}
+	}
+	return nil
+}
+
+// tempPath returns a hidden name next to p. Backends write to it and rename it to
+// p once the content is complete, so a failed upload never replaces a published
+// file with a truncated one.
+func tempPath(p string) string {
+	dir, base := path.Split(p)
+	var random [8]byte
+	rand.Read(random[:])
+	return dir + "." + base + ".tmp-" + hex.EncodeToString(random[:])
+}
+
+// NormalizePath turns a config's Path into a key prefix: empty, or ending in "/"
+func NormalizePath(p string) string {
+	if p == "" || strings.HasSuffix(p, "/") {
+		return p
+	}
+	return p + "/"
+}
+
+// ObjectPath returns where an object is stored below a config's Path
+func ObjectPath(configPath, key string) string {
+	return NormalizePath(configPath) + key
+}
+
+// Open opens the backend selected by the config's Mode, "local" if unset. The
+// config's Path is normalized first, so backends can prefix keys with it.
+func Open(config models.StorageConfig) (Storage, error) {
+	config.Path = NormalizePath(config.Path)
+	mode := config.Mode
+	if mode == "" {
+		mode = "local"
+	}
+
+	driversMu.RLock()
+	driver, ok := drivers[mode]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown storage mode: %s", mode)
+	}
+	return driver(config)
+}
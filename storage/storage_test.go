@@ -0,0 +1,288 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"sitemap-builder/models"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/net/webdav"
+)
+
+// TestDrivers runs the Storage contract against every backend that can run in
+// process: WebDAV and SFTP against in-process servers
+func TestDrivers(t *testing.T) {
+	tests := []struct {
+		name string
+		open func(t *testing.T) Storage
+	}{
+		{"memory", func(t *testing.T) Storage {
+			config := models.StorageConfig{Mode: "memory"}
+			config.ID = 1001
+			return openStorage(t, config)
+		}},
+		{"local", func(t *testing.T) Storage {
+			t.Setenv("LOCAL_STORAGE_ROOT", t.TempDir())
+			return openStorage(t, models.StorageConfig{Mode: "local", Path: "public/sitemaps"})
+		}},
+		{"webdav", func(t *testing.T) Storage {
+			server := httptest.NewServer(&webdav.Handler{Prefix: "/dav", FileSystem: webdav.NewMemFS(), LockSystem: webdav.NewMemLS()})
+			t.Cleanup(server.Close)
+			return openStorage(t, models.StorageConfig{Mode: "webdav", Endpoint: server.URL + "/dav", Path: "public/sitemaps/"})
+		}},
+		{"sftp", func(t *testing.T) Storage {
+			return &sftpStorage{client: newSFTPTestClient(t, t.TempDir()), prefix: "public/sitemaps/"}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testStorageContract(t, tt.open(t))
+		})
+	}
+}
+
+func openStorage(t *testing.T, config models.StorageConfig) Storage {
+	t.Helper()
+	store, err := Open(config)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// newSFTPTestClient connects a client to an in-process SFTP server serving dir
+func newSFTPTestClient(t *testing.T, dir string) *sftp.Client {
+	clientReader, serverWriter := io.Pipe()
+	serverReader, clientWriter := io.Pipe()
+	server, err := sftp.NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{serverReader, serverWriter}, sftp.WithServerWorkingDirectory(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+
+	client, err := sftp.NewClientPipe(clientReader, clientWriter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Closing the server ends the stream the client is reading
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+	return client
+}
+
+func testStorageContract(t *testing.T, store Storage) {
+	ctx := context.Background()
+
+	t.Run("missing objects", func(t *testing.T) {
+		if _, err := store.Stat(ctx, "missing.xml"); !errors.Is(err, ErrNotExist) {
+			t.Errorf("Stat error = %v, want ErrNotExist", err)
+		}
+		if _, err := store.Open(ctx, "missing/a/b.xml"); !errors.Is(err, ErrNotExist) {
+			t.Errorf("Open error = %v, want ErrNotExist", err)
+		}
+		if err := store.Delete(ctx, "missing.xml"); err != nil {
+			t.Errorf("Delete of a missing object: %v", err)
+		}
+	})
+
+	t.Run("put, replace and read back", func(t *testing.T) {
+		key := "a/blog/blog-0001.xml"
+		for _, content := range []string{"<urlset>first</urlset>", "<urlset/>"} {
+			put(t, store, key, content)
+			if got := read(t, store, key); got != content {
+				t.Errorf("Open read %q, want %q", got, content)
+			}
+			info, err := store.Stat(ctx, key)
+			if err != nil {
+				t.Fatalf("Stat: %v", err)
+			}
+			if info.Key != key || info.Size != int64(len(content)) || info.LastModified.IsZero() {
+				t.Errorf("Stat = %+v, want key %s and size %d", info, key, len(content))
+			}
+		}
+	})
+
+	t.Run("list by prefix", func(t *testing.T) {
+		for _, key := range []string{"a.xml", "a/blog/blog-0001.xml", "a/blog/blog-0002.xml", "a/news/news.xml", "ab/x/x.xml"} {
+			put(t, store, key, key)
+		}
+		tests := []struct {
+			prefix string
+			want   []string
+		}{
+			{"a/blog/", []string{"a/blog/blog-0001.xml", "a/blog/blog-0002.xml"}},
+			{"a/blog/blog-", []string{"a/blog/blog-0001.xml", "a/blog/blog-0002.xml"}},
+			{"a/", []string{"a/blog/blog-0001.xml", "a/blog/blog-0002.xml", "a/news/news.xml"}},
+			{"a", []string{"a.xml", "a/blog/blog-0001.xml", "a/blog/blog-0002.xml", "a/news/news.xml", "ab/x/x.xml"}},
+			{"missing/", nil},
+		}
+		for _, tt := range tests {
+			objects, err := store.List(ctx, tt.prefix)
+			if err != nil {
+				t.Fatalf("List(%q): %v", tt.prefix, err)
+			}
+			var keys []string
+			for _, object := range objects {
+				keys = append(keys, object.Key)
+				if object.Size != int64(len(object.Key)) {
+					t.Errorf("List(%q): %s has size %d, want %d", tt.prefix, object.Key, object.Size, len(object.Key))
+				}
+			}
+			sort.Strings(keys)
+			if strings.Join(keys, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("List(%q) = %v, want %v", tt.prefix, keys, tt.want)
+			}
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		put(t, store, "d/d/d-0001.xml", "x")
+		if err := store.Delete(ctx, "d/d/d-0001.xml"); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if _, err := store.Stat(ctx, "d/d/d-0001.xml"); !errors.Is(err, ErrNotExist) {
+			t.Errorf("Stat after Delete = %v, want ErrNotExist", err)
+		}
+		if objects, _ := store.List(ctx, "d/"); len(objects) != 0 {
+			t.Errorf("List after Delete = %v, want nothing", objects)
+		}
+	})
+
+	t.Run("failed put keeps the existing object", func(t *testing.T) {
+		put(t, store, "f/f/f-0001.xml", "<urlset>complete</urlset>")
+
+		readErr := errors.New("read failed")
+		err := store.Put(ctx, "f/f/f-0001.xml", &failingReader{r: strings.NewReader("<urlset>trunc"), err: readErr}, PutOptions{})
+		if err == nil {
+			t.Fatalf("Put succeeded with a failing reader")
+		}
+		if got := read(t, store, "f/f/f-0001.xml"); got != "<urlset>complete</urlset>" {
+			t.Errorf("after a failed Put the object holds %q, want the previous content", got)
+		}
+		objects, err := store.List(ctx, "f/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(objects) != 1 {
+			t.Errorf("List after a failed Put = %v, want only the existing object", objects)
+		}
+
+		// A failed Put of a new key leaves nothing behind
+		store.Put(ctx, "g/g/g-0001.xml", &failingReader{r: strings.NewReader("<url"), err: readErr}, PutOptions{})
+		if _, err := store.Stat(ctx, "g/g/g-0001.xml"); !errors.Is(err, ErrNotExist) {
+			t.Errorf("Stat after a failed Put of a new key = %v, want ErrNotExist", err)
+		}
+		if objects, _ := store.List(ctx, "g/"); len(objects) != 0 {
+			t.Errorf("List after a failed Put of a new key = %v, want nothing", objects)
+		}
+	})
+}
+
+func put(t *testing.T, store Storage, key, content string) {
+	t.Helper()
+	if err := store.Put(context.Background(), key, strings.NewReader(content), PutOptions{ContentType: "application/xml"}); err != nil {
+		t.Fatalf("Put(%s): %v", key, err)
+	}
+}
+
+func read(t *testing.T, store Storage, key string) string {
+	t.Helper()
+	r, err := store.Open(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Open(%s): %v", key, err)
+	}
+	defer r.Close()
+	content, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("reading %s: %v", key, err)
+	}
+	return string(content)
+}
+
+func TestLocalStorageStaysInsideRoot(t *testing.T) {
+	t.Setenv("LOCAL_STORAGE_ROOT", t.TempDir())
+
+	if _, err := Open(models.StorageConfig{Mode: "local", Path: "../outside"}); err == nil {
+		t.Errorf("Open accepted a path outside of the root")
+	}
+
+	store, err := Open(models.StorageConfig{Mode: "local", Path: "public"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"../escape.xml", "a/../../escape.xml"} {
+		if err := store.Put(context.Background(), key, strings.NewReader("x"), PutOptions{}); err == nil {
+			t.Errorf("Put(%q) wrote outside of the storage path", key)
+		}
+	}
+}
+
+func TestCheckPath(t *testing.T) {
+	tests := []struct {
+		path          string
+		allowAbsolute bool
+		ok            bool
+	}{
+		{"", false, true},
+		{"sitemaps/", false, true},
+		{"a/b/c", false, true},
+		{"/var/www/sitemaps/", true, true},
+		{"/var/www/sitemaps/", false, false},
+		{"../sitemaps/", false, false},
+		{"a/../../b", true, false},
+		{`a\b`, false, false},
+		{"a\x00b", false, false},
+	}
+	for _, tt := range tests {
+		if err := checkPath(tt.path, tt.allowAbsolute); (err == nil) != tt.ok {
+			t.Errorf("checkPath(%q, %v) = %v, want ok %v", tt.path, tt.allowAbsolute, err, tt.ok)
+		}
+	}
+}
+
+func TestObjectPath(t *testing.T) {
+	tests := []struct {
+		configPath, key, want string
+	}{
+		{"", "a.xml", "a.xml"},
+		{"sitemaps", "a.xml", "sitemaps/a.xml"},
+		{"sitemaps/", "a.xml", "sitemaps/a.xml"},
+		{"/var/www", "a/b/b-0001.xml", "/var/www/a/b/b-0001.xml"},
+	}
+	for _, tt := range tests {
+		if got := ObjectPath(tt.configPath, tt.key); got != tt.want {
+			t.Errorf("ObjectPath(%q, %q) = %q, want %q", tt.configPath, tt.key, got, tt.want)
+		}
+	}
+}
+
+// TestOpenNormalizesPath checks that a Path with or without a trailing slash
+// addresses the same objects on a backend that prefixes keys with it
+func TestOpenNormalizesPath(t *testing.T) {
+	server := httptest.NewServer(&webdav.Handler{Prefix: "/dav", FileSystem: webdav.NewMemFS(), LockSystem: webdav.NewMemLS()})
+	t.Cleanup(server.Close)
+
+	store := openStorage(t, models.StorageConfig{Mode: "webdav", Endpoint: server.URL + "/dav", Path: "public"})
+	put(t, store, "a.xml", "index")
+	same := openStorage(t, models.StorageConfig{Mode: "webdav", Endpoint: server.URL + "/dav", Path: "public/"})
+	if got := read(t, same, "a.xml"); got != "index" {
+		t.Errorf("read %q through the path with a trailing slash, want %q", got, "index")
+	}
+}
+
+func TestOpenUnknownMode(t *testing.T) {
+	if _, err := Open(models.StorageConfig{Mode: "ftp"}); err == nil {
+		t.Errorf("Open accepted an unknown mode")
+	}
+}
@@ -0,0 +1,292 @@
+package storage
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sitemap-builder/models"
+	"sitemap-builder/secrets"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	Register("webdav", newWebDAVStorage)
+}
+
+// webdavStorage stores objects on a WebDAV server. The config's Endpoint is the
+// base URL and objects are stored below its Path.
+type webdavStorage struct {
+	client   *http.Client
+	base     *url.URL
+	prefix   string
+	username string
+	password string
+}
+
+func newWebDAVStorage(config models.StorageConfig) (Storage, error) {
+	base, err := url.Parse(config.Endpoint)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("webdav storage requires an http(s) endpoint")
+	}
+	// An absolute path would resolve outside of the endpoint's path
+	if err := checkPath(config.Path, false); err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+
+	password, err := secrets.Resolve(string(config.Password))
+	if err != nil {
+		return nil, err
+	}
+
+	return &webdavStorage{
+		client:   &http.Client{Timeout: 5 * time.Minute},
+		base:     base,
+		prefix:   config.Path,
+		username: config.Username,
+		password: password,
+	}, nil
+}
+
+// url returns the URL of a path relative to the endpoint
+func (s *webdavStorage) url(p string) string {
+	return s.base.ResolveReference(&url.URL{Path: p}).String()
+}
+
+func (s *webdavStorage) do(ctx context.Context, method, target string, body io.Reader, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if s.username != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+	return s.client.Do(req)
+}
+
+// mkdirAll creates the collections above a path, ignoring ones that already exist
+func (s *webdavStorage) mkdirAll(ctx context.Context, p string) error {
+	dirs := strings.Split(path.Dir(p), "/")
+	for i := range dirs {
+		if dirs[i] == "." || dirs[i] == "" {
+			continue
+		}
+		resp, err := s.do(ctx, "MKCOL", s.url(strings.Join(dirs[:i+1], "/")+"/"), nil, nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		// 405 means the collection exists
+		if resp.StatusCode >= 300 && resp.StatusCode != http.StatusMethodNotAllowed {
+			return fmt.Errorf("webdav MKCOL status code %d", resp.StatusCode)
+		}
+	}
+	return nil
+}
+
+func (s *webdavStorage) Put(ctx context.Context, key string, r io.Reader, opts PutOptions) error {
+	p := s.prefix + key
+	if err := s.mkdirAll(ctx, p); err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	if opts.ContentType != "" {
+		header.Set("Content-Type", opts.ContentType)
+	}
+	// Upload next to the destination and MOVE it over, so a failed upload
+	// leaves the published file as it was
+	tmp := s.url(tempPath(p))
+	err := s.request(ctx, http.MethodPut, tmp, r, header)
+	if err == nil {
+		err = s.request(ctx, "MOVE", tmp, nil, http.Header{"Destination": {s.url(p)}, "Overwrite": {"T"}})
+	}
+	if err != nil {
+		// The upload may have been cancelled, so the cleanup gets its own context
+		s.request(context.Background(), http.MethodDelete, tmp, nil, nil)
+	}
+	return err
+}
+
+// request sends a request that must succeed and has no response body of interest
+func (s *webdavStorage) request(ctx context.Context, method, target string, body io.Reader, header http.Header) error {
+	resp, err := s.do(ctx, method, target, body, header)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webdav %s status code %d", method, resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *webdavStorage) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, s.url(s.prefix+key), nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("webdav DELETE status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+type davMultistatus struct {
+	Responses []davResponse `xml:"response"`
+}
+
+type davResponse struct {
+	Href string `xml:"href"`
+	Prop struct {
+		ResourceType struct {
+			Collection *struct{} `xml:"collection"`
+		} `xml:"resourcetype"`
+		ContentLength string `xml:"getcontentlength"`
+		LastModified  string `xml:"getlastmodified"`
+		ETag          string `xml:"getetag"`
+	} `xml:"propstat>prop"`
+}
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<propfind xmlns="DAV:"><prop><resourcetype/><getcontentlength/><getlastmodified/><getetag/></prop></propfind>`
+
+// propfind lists the members of a collection (Depth: 1)
+func (s *webdavStorage) propfind(ctx context.Context, dir string) ([]davResponse, error) {
+	header := http.Header{}
+	header.Set("Depth", "1")
+	header.Set("Content-Type", "application/xml")
+	resp, err := s.do(ctx, "PROPFIND", s.url(dir), strings.NewReader(propfindBody), header)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotExist
+	}
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("webdav PROPFIND status code %d", resp.StatusCode)
+	}
+
+	var status davMultistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, err
+	}
+	return status.Responses, nil
+}
+
+// relativePath converts a PROPFIND href to a path relative to the endpoint
+func (s *webdavStorage) relativePath(href string) string {
+	if u, err := url.Parse(href); err == nil {
+		href = u.Path
+	}
+	return strings.TrimPrefix(href, s.base.Path)
+}
+
+func (s *webdavStorage) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	full := s.prefix + prefix
+	start := ""
+	if i := strings.LastIndex(full, "/"); i >= 0 {
+		start = full[:i+1]
+	}
+
+	var objects []ObjectInfo
+	dirs := []string{start}
+	for len(dirs) > 0 {
+		dir := dirs[0]
+		dirs = dirs[1:]
+
+		members, err := s.propfind(ctx, dir)
+		if err == ErrNotExist {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, member := range members {
+			p := s.relativePath(member.Href)
+			if strings.TrimSuffix(p, "/") == strings.TrimSuffix(dir, "/") {
+				continue // the collection itself
+			}
+			if member.Prop.ResourceType.Collection != nil {
+				if !strings.HasSuffix(p, "/") {
+					p += "/"
+				}
+				dirs = append(dirs, p)
+				continue
+			}
+			if strings.HasPrefix(p, full) {
+				objects = append(objects, davObjectInfo(strings.TrimPrefix(p, s.prefix), member))
+			}
+		}
+	}
+	return objects, nil
+}
+
+func davObjectInfo(key string, member davResponse) ObjectInfo {
+	size, _ := strconv.ParseInt(member.Prop.ContentLength, 10, 64)
+	modified, _ := http.ParseTime(member.Prop.LastModified)
+	return ObjectInfo{
+		Key:          key,
+		Size:         size,
+		LastModified: modified,
+		ETag:         strings.Trim(member.Prop.ETag, `"`),
+	}
+}
+
+func (s *webdavStorage) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	resp, err := s.do(ctx, http.MethodHead, s.url(s.prefix+key), nil, nil)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return ObjectInfo{}, ErrNotExist
+	}
+	if resp.StatusCode >= 300 {
+		return ObjectInfo{}, fmt.Errorf("webdav HEAD status code %d", resp.StatusCode)
+	}
+
+	modified, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return ObjectInfo{
+		Key:          key,
+		Size:         resp.ContentLength,
+		LastModified: modified,
+		ETag:         strings.Trim(resp.Header.Get("ETag"), `"`),
+	}, nil
+}
+
+func (s *webdavStorage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, s.url(s.prefix+key), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrNotExist
+	}
+	if resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("webdav GET status code %d", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+func (s *webdavStorage) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
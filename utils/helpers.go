@@ -5,7 +5,9 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"net/url"
 	"sitemap-builder/models"
+	"sitemap-builder/secrets"
 	"strings"
 
 	"gorm.io/driver/sqlite"
@@ -43,13 +45,25 @@ func BuildURL(baseURL, pattern string, data map[string]interface{}) string {
 func ConnectToDatasource(datasource *models.Datasource) (*gorm.DB, error) {
 	var db *gorm.DB
 	var err error
+
+	// Resolve secret references; "file:" is also SQLite's URI scheme, so SQLite
+	// connection strings starting with it only get their embedded references resolved
+	connectionString := string(datasource.ConnectionString)
+	if datasource.Type == "sqlite" && strings.HasPrefix(connectionString, "file:") {
+		connectionString, err = secrets.ResolveTemplates(connectionString)
+	} else {
+		connectionString, err = secrets.Resolve(connectionString)
+	}
+	if err != nil {
+		return nil, err
+	}
 	
 	switch datasource.Type {
 	case "sqlite":
-		db, err = gorm.Open(sqlite.Open(datasource.ConnectionString), &gorm.Config{})
+		db, err = gorm.Open(sqlite.Open(connectionString), &gorm.Config{})
 	// Add support for other database types as needed
 	case "pgsql":
-		db, err = gorm.Open(postgres.Open(datasource.ConnectionString), &gorm.Config{})
+		db, err = gorm.Open(postgres.Open(connectionString), &gorm.Config{})
 	// Add support for other database types as needed
 	default:
 		return nil, fmt.Errorf("unsupported datasource type: %s", datasource.Type)
@@ -124,4 +138,87 @@ func GetValueOrDefault(value interface{}, def string) string {
         return def
     }
     return fmt.Sprintf("%v", value)
-}
\ No newline at end of file
+}
+// ParseSeedURLs parses a comma or whitespace separated list of absolute http(s) URLs
+func ParseSeedURLs(seeds string) ([]*url.URL, error) {
+	var urls []*url.URL
+	for _, raw := range strings.FieldsFunc(seeds, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\n' || r == '\t' || r == '\r'
+	}) {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid seed URL %q: %v", raw, err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("seed URL %q must be an absolute http(s) URL", raw)
+		}
+		urls = append(urls, u)
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("no seed URLs given")
+	}
+	return urls, nil
+}
+
+// ParseDate parses a date column value, which may be a time.Time or a string in a common layout
+func ParseDate(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case nil:
+		return time.Time{}, false
+	case time.Time:
+		return v, !v.IsZero()
+	}
+
+	dateStr := strings.TrimSpace(fmt.Sprintf("%v", value))
+	layouts := []string{
+		time.RFC3339,
+		"2006-01-02",
+		"2006-01-02T15:04",
+		"2006-01-02 15:04:05",
+		"2006-01-02 15:04:05.999999999 -0700 MST",
+		time.RFC1123,
+		time.RFC1123Z,
+	}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, dateStr); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// FormatLastMod formats a date column value as a W3C datetime for <lastmod>
+func FormatLastMod(value interface{}) (string, bool) {
+	t, ok := ParseDate(value)
+	if !ok {
+		return "", false
+	}
+	if t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 && t.Location() == time.UTC {
+		return t.Format("2006-01-02"), true
+	}
+	return t.Format(time.RFC3339), true
+}
+
+// IsValidChangeFreq reports whether value is one of the sitemap protocol's changefreq values
+func IsValidChangeFreq(value string) bool {
+	switch value {
+	case "always", "hourly", "daily", "weekly", "monthly", "yearly", "never":
+		return true
+	}
+	return false
+}
+
+// IsSafeFileName reports whether a sitemap or sitemap index name can be used as a
+// file name without escaping the storage directory
+func IsSafeFileName(name string) bool {
+	if name == "" || name == "." || name == ".." {
+		return false
+	}
+	return !strings.ContainsAny(name, "/\\\x00")
+}
+
+// IsAbsoluteHTTPURL reports whether value is an absolute http or https URL with a host
+func IsAbsoluteHTTPURL(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
@@ -0,0 +1,85 @@
+// utils/html.go
+package utils
+
+import (
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// HTMLPage holds the parts of an HTML document relevant to sitemaps
+type HTMLPage struct {
+	Title      string
+	Canonical  string
+	MetaRobots string
+	Links      []string
+}
+
+// ParseHTML extracts the title, canonical link, robots meta tag and outgoing links from an HTML document
+func ParseHTML(r io.Reader) (*HTMLPage, error) {
+	page := &HTMLPage{}
+	tokenizer := html.NewTokenizer(r)
+	inTitle := false
+
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			if err := tokenizer.Err(); err != io.EOF {
+				return page, err
+			}
+			return page, nil
+
+		case html.TextToken:
+			if inTitle && page.Title == "" {
+				page.Title = strings.TrimSpace(html.UnescapeString(string(tokenizer.Text())))
+			}
+
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			if string(name) == "title" {
+				inTitle = false
+			}
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := tokenizer.TagName()
+			tag := string(name)
+			if tag == "title" {
+				inTitle = true
+				continue
+			}
+
+			attrs := map[string]string{}
+			for hasAttr {
+				var key, value []byte
+				key, value, hasAttr = tokenizer.TagAttr()
+				attrs[strings.ToLower(string(key))] = string(value)
+			}
+
+			switch tag {
+			case "a":
+				if href := strings.TrimSpace(attrs["href"]); href != "" {
+					page.Links = append(page.Links, href)
+				}
+			case "link":
+				if hasToken(attrs["rel"], "canonical") && page.Canonical == "" {
+					page.Canonical = strings.TrimSpace(attrs["href"])
+				}
+			case "meta":
+				if strings.EqualFold(attrs["name"], "robots") {
+					page.MetaRobots = attrs["content"]
+				}
+			}
+		}
+	}
+}
+
+// hasToken reports whether a space separated attribute value contains the token
+func hasToken(value, token string) bool {
+	for _, field := range strings.Fields(value) {
+		if strings.EqualFold(field, token) {
+			return true
+		}
+	}
+	return false
+}
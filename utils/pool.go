@@ -0,0 +1,171 @@
+// utils/pool.go
+package utils
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"sitemap-builder/models"
+	"strconv"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Pools is the shared connection manager for external datasources
+var Pools = NewPoolManager()
+
+// PoolManager caches one connection pool per datasource, so generation runs
+// and API calls reuse connections instead of opening a new one every time
+type PoolManager struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+
+	mu    sync.Mutex
+	pools map[uint]*datasourcePool
+}
+
+type datasourcePool struct {
+	db        *gorm.DB
+	sqlDB     *sql.DB
+	updatedAt time.Time
+}
+
+// PoolStats describes a cached connection pool
+type PoolStats struct {
+	DatasourceID      uint   `json:"datasource_id"`
+	MaxOpenConns      int    `json:"max_open_conns"`
+	OpenConnections   int    `json:"open_connections"`
+	InUse             int    `json:"in_use"`
+	Idle              int    `json:"idle"`
+	WaitCount         int64  `json:"wait_count"`
+	WaitDuration      string `json:"wait_duration"`
+	MaxIdleClosed     int64  `json:"max_idle_closed"`
+	MaxLifetimeClosed int64  `json:"max_lifetime_closed"`
+}
+
+// NewPoolManager creates a pool manager with limits from the DATASOURCE_MAX_OPEN_CONNS,
+// DATASOURCE_MAX_IDLE_CONNS and DATASOURCE_CONN_MAX_LIFETIME (seconds) environment variables
+func NewPoolManager() *PoolManager {
+	return &PoolManager{
+		MaxOpenConns:    envInt("DATASOURCE_MAX_OPEN_CONNS", 10),
+		MaxIdleConns:    envInt("DATASOURCE_MAX_IDLE_CONNS", 2),
+		ConnMaxLifetime: time.Duration(envInt("DATASOURCE_CONN_MAX_LIFETIME", 1800)) * time.Second,
+		pools:           make(map[uint]*datasourcePool),
+	}
+}
+
+// Get returns the cached pool for a datasource, connecting first if there is
+// none or the datasource changed since the pool was opened
+func (m *PoolManager) Get(datasource *models.Datasource) (*gorm.DB, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if pool, ok := m.pools[datasource.ID]; ok {
+		if pool.updatedAt.Equal(datasource.UpdatedAt) {
+			return pool.db, nil
+		}
+		pool.sqlDB.Close()
+		delete(m.pools, datasource.ID)
+	}
+
+	db, err := ConnectToDatasource(datasource)
+	if err != nil {
+		return nil, err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+
+	maxOpen, maxIdle := m.MaxOpenConns, m.MaxIdleConns
+	if datasource.MaxOpenConns > 0 {
+		maxOpen = datasource.MaxOpenConns
+	}
+	if datasource.MaxIdleConns > 0 {
+		maxIdle = datasource.MaxIdleConns
+	}
+	sqlDB.SetMaxOpenConns(maxOpen)
+	sqlDB.SetMaxIdleConns(maxIdle)
+	sqlDB.SetConnMaxLifetime(m.ConnMaxLifetime)
+
+	m.pools[datasource.ID] = &datasourcePool{db: db, sqlDB: sqlDB, updatedAt: datasource.UpdatedAt}
+	return db, nil
+}
+
+// Invalidate closes and forgets the pool of a datasource, e.g. after it was updated or deleted
+func (m *PoolManager) Invalidate(datasourceID uint) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if pool, ok := m.pools[datasourceID]; ok {
+		pool.sqlDB.Close()
+		delete(m.pools, datasourceID)
+	}
+}
+
+// Ping checks that the datasource is reachable through its pool and returns the round trip time
+func (m *PoolManager) Ping(datasource *models.Datasource) (time.Duration, error) {
+	db, err := m.Get(datasource)
+	if err != nil {
+		return 0, fmt.Errorf("connection failed: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return 0, fmt.Errorf("connection pool error: %v", err)
+	}
+
+	start := time.Now()
+	if err := sqlDB.Ping(); err != nil {
+		return 0, fmt.Errorf("ping failed: %v", err)
+	}
+	return time.Since(start), nil
+}
+
+// Stats returns the statistics of every cached pool
+func (m *PoolManager) Stats() []PoolStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := make([]PoolStats, 0, len(m.pools))
+	for id, pool := range m.pools {
+		stats = append(stats, newPoolStats(id, pool.sqlDB.Stats()))
+	}
+	return stats
+}
+
+// StatsFor returns the statistics of a datasource's pool, if one is cached
+func (m *PoolManager) StatsFor(datasourceID uint) (PoolStats, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pool, ok := m.pools[datasourceID]
+	if !ok {
+		return PoolStats{}, false
+	}
+	return newPoolStats(datasourceID, pool.sqlDB.Stats()), true
+}
+
+func newPoolStats(datasourceID uint, s sql.DBStats) PoolStats {
+	return PoolStats{
+		DatasourceID:      datasourceID,
+		MaxOpenConns:      s.MaxOpenConnections,
+		OpenConnections:   s.OpenConnections,
+		InUse:             s.InUse,
+		Idle:              s.Idle,
+		WaitCount:         s.WaitCount,
+		WaitDuration:      s.WaitDuration.String(),
+		MaxIdleClosed:     s.MaxIdleClosed,
+		MaxLifetimeClosed: s.MaxLifetimeClosed,
+	}
+}
+
+// envInt reads a positive integer from the environment, falling back to def
+func envInt(name string, def int) int {
+	if value, err := strconv.Atoi(os.Getenv(name)); err == nil && value > 0 {
+		return value
+	}
+	return def
+}
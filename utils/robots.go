@@ -0,0 +1,199 @@
+// utils/robots.go
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Robots is a parsed robots.txt file
+type Robots struct {
+	groups   []robotsGroup
+	Sitemaps []string
+}
+
+type robotsGroup struct {
+	agents     []string
+	rules      []robotsRule
+	crawlDelay time.Duration
+}
+
+type robotsRule struct {
+	allow   bool
+	pattern string
+	re      *regexp.Regexp
+}
+
+// ParseRobots parses the content of a robots.txt file
+func ParseRobots(data []byte) *Robots {
+	robots := &Robots{}
+	var current *robotsGroup
+	inAgents := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			// Consecutive user-agent lines share the same group
+			if !inAgents {
+				robots.groups = append(robots.groups, robotsGroup{})
+				current = &robots.groups[len(robots.groups)-1]
+			}
+			current.agents = append(current.agents, strings.ToLower(value))
+			inAgents = true
+		case "allow", "disallow":
+			inAgents = false
+			if current == nil || value == "" {
+				continue
+			}
+			current.rules = append(current.rules, robotsRule{
+				allow:   key == "allow",
+				pattern: value,
+				re:      compileRobotsPattern(value),
+			})
+		case "crawl-delay":
+			inAgents = false
+			if current == nil {
+				continue
+			}
+			if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+				current.crawlDelay = time.Duration(seconds * float64(time.Second))
+			}
+		case "sitemap":
+			robots.Sitemaps = append(robots.Sitemaps, value)
+		default:
+			inAgents = false
+		}
+	}
+
+	return robots
+}
+
+// compileRobotsPattern turns a robots.txt path pattern with * and $ wildcards into a regexp
+func compileRobotsPattern(pattern string) *regexp.Regexp {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+
+	expr := "^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*")
+	if anchored {
+		expr += "$"
+	}
+	return regexp.MustCompile(expr)
+}
+
+// group returns the group that applies to the given user agent, or nil if none does
+func (r *Robots) group(userAgent string) *robotsGroup {
+	userAgent = strings.ToLower(userAgent)
+
+	var best *robotsGroup
+	bestLen := -1
+	for i := range r.groups {
+		for _, agent := range r.groups[i].agents {
+			matchLen := -1
+			if agent == "*" {
+				matchLen = 0
+			} else if strings.Contains(userAgent, agent) {
+				matchLen = len(agent)
+			}
+			if matchLen > bestLen {
+				best = &r.groups[i]
+				bestLen = matchLen
+			}
+		}
+	}
+	return best
+}
+
+// Allowed reports whether the given user agent may fetch the path (including query)
+func (r *Robots) Allowed(userAgent, path string) bool {
+	group := r.group(userAgent)
+	if group == nil {
+		return true
+	}
+	if path == "" {
+		path = "/"
+	}
+
+	// The most specific (longest) matching rule wins, allow wins ties
+	allowed := true
+	matchLen := -1
+	for _, rule := range group.rules {
+		if !rule.re.MatchString(path) {
+			continue
+		}
+		if len(rule.pattern) > matchLen || (len(rule.pattern) == matchLen && rule.allow) {
+			allowed = rule.allow
+			matchLen = len(rule.pattern)
+		}
+	}
+	return allowed
+}
+
+// AllowedURL reports whether the given user agent may fetch the URL
+func (r *Robots) AllowedURL(userAgent string, u *url.URL) bool {
+	path := u.EscapedPath()
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	return r.Allowed(userAgent, path)
+}
+
+// CrawlDelay returns the crawl delay requested for the given user agent
+func (r *Robots) CrawlDelay(userAgent string) time.Duration {
+	if group := r.group(userAgent); group != nil {
+		return group.crawlDelay
+	}
+	return 0
+}
+
+// FetchRobots downloads and parses robots.txt for the host of the given URL.
+// A missing robots.txt (4xx) allows everything.
+func FetchRobots(client *http.Client, userAgent string, u *url.URL) (*Robots, error) {
+	robotsURL := fmt.Sprintf("%s://%s/robots.txt", u.Scheme, u.Host)
+	req, err := http.NewRequest(http.MethodGet, robotsURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if userAgent != "" {
+		req.Header.Set("User-Agent", userAgent)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+		return &Robots{}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("robots.txt status code %d", resp.StatusCode)
+	}
+
+	// Crawlers are only required to read the first 500 KiB
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 500*1024))
+	if err != nil {
+		return nil, err
+	}
+	return ParseRobots(data), nil
+}
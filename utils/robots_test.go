@@ -0,0 +1,174 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testRobots = `# Example robots.txt
+User-agent: *
+Disallow: /private/
+Allow: /private/public-page
+Disallow: /*.pdf$
+Disallow: /search?
+Crawl-delay: 2
+
+User-agent: Googlebot
+User-agent: Bingbot   # shares the group
+Disallow: /no-bots/
+Disallow: /tie
+Allow: /tie
+Crawl-delay: 0.5
+
+user-agent: SpecialBot
+DISALLOW: /
+
+User-agent: EmptyBot
+Disallow:
+
+Sitemap: https://example.com/sitemap.xml
+Sitemap: https://example.com/news.xml
+`
+
+func TestRobotsAllowed(t *testing.T) {
+	robots := ParseRobots([]byte(testRobots))
+
+	tests := []struct {
+		userAgent string
+		path      string
+		allowed   bool
+	}{
+		{"SitemapBuilderBot/1.0", "/", true},
+		{"SitemapBuilderBot/1.0", "", true},
+		{"SitemapBuilderBot/1.0", "/private/", false},
+		{"SitemapBuilderBot/1.0", "/private/page", false},
+		{"SitemapBuilderBot/1.0", "/private/public-page", true},
+		{"SitemapBuilderBot/1.0", "/private/public-page/child", true},
+		{"SitemapBuilderBot/1.0", "/privateer", true},
+		{"SitemapBuilderBot/1.0", "/files/report.pdf", false},
+		{"SitemapBuilderBot/1.0", "/files/report.pdf?download=1", true},
+		{"SitemapBuilderBot/1.0", "/search?q=sitemaps", false},
+		{"SitemapBuilderBot/1.0", "/search", true},
+		// Googlebot and Bingbot use their own group, not the * one
+		{"Mozilla/5.0 (compatible; Googlebot/2.1)", "/private/page", true},
+		{"Mozilla/5.0 (compatible; Googlebot/2.1)", "/no-bots/page", false},
+		{"bingbot/2.0", "/no-bots/page", false},
+		// Allow wins ties between rules of the same length
+		{"Googlebot", "/tie", true},
+		{"SpecialBot", "/", false},
+		{"SpecialBot", "/anything", false},
+		{"EmptyBot", "/private/page", true},
+	}
+	for _, tt := range tests {
+		if got := robots.Allowed(tt.userAgent, tt.path); got != tt.allowed {
+			t.Errorf("Allowed(%q, %q) = %v, want %v", tt.userAgent, tt.path, got, tt.allowed)
+		}
+	}
+}
+
+func TestRobotsAllowedURL(t *testing.T) {
+	robots := ParseRobots([]byte("User-agent: *\nDisallow: /a%20b\nDisallow: /*?sort=\n"))
+
+	tests := []struct {
+		rawURL  string
+		allowed bool
+	}{
+		{"https://example.com/a%20b/page", false},
+		{"https://example.com/a/b", true},
+		{"https://example.com/list?sort=asc", false},
+		{"https://example.com/list?page=2", true},
+		{"https://example.com", true},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.rawURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := robots.AllowedURL("bot", u); got != tt.allowed {
+			t.Errorf("AllowedURL(%s) = %v, want %v", tt.rawURL, got, tt.allowed)
+		}
+	}
+}
+
+func TestRobotsCrawlDelayAndSitemaps(t *testing.T) {
+	robots := ParseRobots([]byte(testRobots))
+
+	tests := []struct {
+		userAgent string
+		delay     time.Duration
+	}{
+		{"SitemapBuilderBot", 2 * time.Second},
+		{"Googlebot", 500 * time.Millisecond},
+		{"SpecialBot", 0},
+	}
+	for _, tt := range tests {
+		if got := robots.CrawlDelay(tt.userAgent); got != tt.delay {
+			t.Errorf("CrawlDelay(%q) = %v, want %v", tt.userAgent, got, tt.delay)
+		}
+	}
+
+	want := []string{"https://example.com/sitemap.xml", "https://example.com/news.xml"}
+	if !reflect.DeepEqual(robots.Sitemaps, want) {
+		t.Errorf("Sitemaps = %v, want %v", robots.Sitemaps, want)
+	}
+}
+
+func TestRobotsWithoutGroups(t *testing.T) {
+	for _, content := range []string{"", "# nothing here\n", "Disallow: /before-any-group\n", "Sitemap: https://example.com/s.xml\n"} {
+		robots := ParseRobots([]byte(content))
+		if !robots.Allowed("bot", "/before-any-group") {
+			t.Errorf("%q disallows a path without a user-agent group", content)
+		}
+		if robots.CrawlDelay("bot") != 0 {
+			t.Errorf("%q has a crawl delay without a user-agent group", content)
+		}
+	}
+}
+
+func TestFetchRobots(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantErr bool
+		allowed bool
+	}{
+		{"rules", http.StatusOK, "User-agent: *\nDisallow: /private/\n", false, false},
+		{"missing", http.StatusNotFound, "", false, true},
+		{"forbidden", http.StatusForbidden, "", false, true},
+		{"server error", http.StatusInternalServerError, "", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var userAgent, path string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				userAgent, path = r.UserAgent(), r.URL.Path
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			u, _ := url.Parse(server.URL + "/some/page?x=1")
+			robots, err := FetchRobots(server.Client(), "TestBot/1.0", u)
+			if path != "/robots.txt" || userAgent != "TestBot/1.0" {
+				t.Errorf("requested %s as %q, want /robots.txt as TestBot/1.0", path, userAgent)
+			}
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("FetchRobots succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("FetchRobots: %v", err)
+			}
+			if got := robots.Allowed("TestBot/1.0", "/private/page"); got != tt.allowed {
+				t.Errorf("Allowed = %v, want %v", got, tt.allowed)
+			}
+		})
+	}
+}